@@ -21,7 +21,6 @@ import (
 	"github.com/weaveworks/libgitops/pkg/storage"
 	"github.com/weaveworks/libgitops/pkg/storage/transaction"
 	githubpr "github.com/weaveworks/libgitops/pkg/storage/transaction/pullrequest/github"
-	"github.com/weaveworks/libgitops/pkg/storage/watch"
 	"github.com/weaveworks/libgitops/pkg/storage/watch/update"
 )
 
@@ -130,18 +129,17 @@ func run(identityFile, gitURL, ghToken, authorName, authorEmail string) error {
 	// Set the log level
 	logs.Logger.SetLevel(logrus.InfoLevel)
 
-	watchStorage, err := watch.NewManifestStorage(gitDir.Dir(), scheme.Serializer)
-	if err != nil {
-		return err
+	// Subscribe to the changes of objects between commits
+	eventStorage, ok := gitStorage.(update.EventStorage)
+	if !ok {
+		return fmt.Errorf("expected the GitStorage to be an EventStorage")
 	}
-	defer func() { _ = watchStorage.Close() }()
-
 	updates := make(chan update.Update, 4096)
-	watchStorage.SetUpdateStream(updates)
+	eventStorage.SetUpdateStream(updates)
 
 	go func() {
 		for upd := range updates {
-			logrus.Infof("Got %s update for: %v %v", upd.Event, upd.PartialObject.GetObjectKind().GroupVersionKind(), upd.PartialObject.GetObjectMeta())
+			logrus.Infof("Got %s update at %s for: %v %v", upd.Event, upd.Revision, upd.PartialObject.GetObjectKind().GroupVersionKind(), upd.PartialObject.GetObjectMeta())
 		}
 	}()
 
@@ -162,7 +160,7 @@ func run(identityFile, gitURL, ghToken, authorName, authorEmail string) error {
 		}
 
 		objKey := common.CarKeyForName(name)
-		err := gitStorage.Transaction(context.Background(), fmt.Sprintf("%s-update-", name), func(ctx context.Context, s storage.Storage) (transaction.CommitResult, error) {
+		pr, err := gitStorage.Transaction(context.Background(), fmt.Sprintf("%s-update-", name), func(ctx context.Context, s storage.Storage) (transaction.CommitResult, error) {
 
 			// Update the status of the car
 			if err := common.SetNewCarStatus(s, objKey); err != nil {
@@ -185,7 +183,11 @@ func run(identityFile, gitURL, ghToken, authorName, authorEmail string) error {
 			return err
 		}
 
-		return c.String(200, "OK!")
+		// The PullRequestProvider might not return a Pull Request, even without an error
+		if pr == nil {
+			return c.String(200, "OK! No PR was created")
+		}
+		return c.String(200, fmt.Sprintf("OK! Created PR: %s", pr.URL))
 	})
 
 	return common.StartEcho(e)
@@ -10,6 +10,7 @@ replace (
 require (
 	github.com/fluxcd/go-git-providers v0.0.2
 	github.com/fluxcd/toolkit v0.0.1-beta.2
+	github.com/go-git/go-billy/v5 v5.0.0
 	github.com/go-git/go-git/v5 v5.1.0
 	github.com/go-openapi/spec v0.19.8
 	github.com/google/go-github/v32 v32.1.0
@@ -21,6 +22,7 @@ require (
 	github.com/sirupsen/logrus v1.6.0
 	github.com/spf13/pflag v1.0.5
 	github.com/stretchr/testify v1.6.1
+	golang.org/x/crypto v0.0.0-20200622213623-75b288015ac9
 	golang.org/x/net v0.0.0-20200625001655-4c5254603344 // indirect
 	golang.org/x/sys v0.0.0-20200812155832-6a926be9bd1d
 	k8s.io/apimachinery v0.18.6
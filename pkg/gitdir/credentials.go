@@ -0,0 +1,133 @@
+package gitdir
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io/ioutil"
+	"sync"
+
+	"github.com/fluxcd/go-git-providers/gitprovider"
+	log "github.com/sirupsen/logrus"
+)
+
+// AuthMethodProvider provides the AuthMethod to use for a Git operation. It's consulted before
+// each clone, fetch, pull and push, so that credentials like short-lived tokens or deploy keys
+// can be rotated during the lifetime of the gitDirectory.
+type AuthMethodProvider interface {
+	// AuthMethod returns the AuthMethod to use for the next Git operation.
+	AuthMethod(ctx context.Context) (AuthMethod, error)
+	// TransportType returns the transport type of the provided AuthMethods. It must not change
+	// between calls, as it determines the clone URL of the repository.
+	TransportType() gitprovider.TransportType
+}
+
+// NewStaticAuthMethodProvider returns an AuthMethodProvider that always provides the given AuthMethod.
+func NewStaticAuthMethodProvider(authMethod AuthMethod) AuthMethodProvider {
+	return &staticAuthMethodProvider{authMethod}
+}
+
+type staticAuthMethodProvider struct {
+	authMethod AuthMethod
+}
+
+func (p *staticAuthMethodProvider) AuthMethod(_ context.Context) (AuthMethod, error) {
+	return p.authMethod, nil
+}
+
+func (p *staticAuthMethodProvider) TransportType() gitprovider.TransportType {
+	return p.authMethod.TransportType()
+}
+
+// AuthMethodFunc creates an AuthMethod from the contents of the files given to NewFileAuthMethodProvider,
+// in the same order.
+type AuthMethodFunc func(contents [][]byte) (AuthMethod, error)
+
+// NewFileAuthMethodProvider returns an AuthMethodProvider that reads the given files before each Git
+// operation, and creates a new AuthMethod using fn if their contents have changed. This is useful for
+// credentials mounted from e.g. Kubernetes Secrets, which are updated on disk when they are rotated.
+// The files are read once when creating the provider, so that invalid credentials are detected early.
+func NewFileAuthMethodProvider(t gitprovider.TransportType, files []string, fn AuthMethodFunc) (AuthMethodProvider, error) {
+	p := &fileAuthMethodProvider{t: t, files: files, fn: fn}
+	if _, err := p.AuthMethod(context.Background()); err != nil {
+		return nil, err
+	}
+	return p, nil
+}
+
+// NewSSHFileAuthMethodProvider returns an AuthMethodProvider which reloads the identity and known_hosts files
+// at the given paths when they change. See NewSSHAuthMethod for the file contents.
+func NewSSHFileAuthMethodProvider(identityFile, knownHostsFile string) (AuthMethodProvider, error) {
+	return NewFileAuthMethodProvider(gitprovider.TransportTypeGit, []string{identityFile, knownHostsFile},
+		func(contents [][]byte) (AuthMethod, error) {
+			return NewSSHAuthMethod(contents[0], contents[1])
+		})
+}
+
+// NewHTTPSFileAuthMethodProvider returns an AuthMethodProvider which reloads the password or token file at the
+// given path when it changes. Surrounding whitespace, like a trailing newline, is trimmed from the file content.
+// For GitHub App installation tokens, use "x-access-token" as the username, and for GitLab tokens "oauth2".
+func NewHTTPSFileAuthMethodProvider(username, passwordFile string) (AuthMethodProvider, error) {
+	return NewFileAuthMethodProvider(gitprovider.TransportTypeHTTPS, []string{passwordFile},
+		func(contents [][]byte) (AuthMethod, error) {
+			return NewHTTPSAuthMethod(username, string(bytes.TrimSpace(contents[0])))
+		})
+}
+
+type fileAuthMethodProvider struct {
+	t     gitprovider.TransportType
+	files []string
+	fn    AuthMethodFunc
+
+	// the file contents the current authMethod was created from
+	contents   [][]byte
+	authMethod AuthMethod
+	mu         sync.Mutex
+}
+
+func (p *fileAuthMethodProvider) AuthMethod(_ context.Context) (AuthMethod, error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	// Read all files, the credentials are small enough to be compared directly
+	contents := make([][]byte, 0, len(p.files))
+	for _, file := range p.files {
+		content, err := ioutil.ReadFile(file)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read credentials: %w", err)
+		}
+		contents = append(contents, content)
+	}
+	if p.authMethod != nil && equalContents(p.contents, contents) {
+		return p.authMethod, nil
+	}
+
+	authMethod, err := p.fn(contents)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create auth method from %v: %w", p.files, err)
+	}
+	if authMethod.TransportType() != p.t {
+		return nil, fmt.Errorf("auth method has transport type %q, expected %q", authMethod.TransportType(), p.t)
+	}
+	if p.authMethod != nil {
+		log.Infof("Credentials in %v changed, reloaded the auth method", p.files)
+	}
+	p.contents, p.authMethod = contents, authMethod
+	return authMethod, nil
+}
+
+func (p *fileAuthMethodProvider) TransportType() gitprovider.TransportType {
+	return p.t
+}
+
+func equalContents(a, b [][]byte) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for i := range a {
+		if !bytes.Equal(a[i], b[i]) {
+			return false
+		}
+	}
+	return true
+}
@@ -0,0 +1,60 @@
+package gitdir
+
+import (
+	"context"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/go-git/go-git/v5/plumbing/transport/http"
+)
+
+func TestHTTPSFileAuthMethodProvider(t *testing.T) {
+	tmpDir, err := ioutil.TempDir("", "libgitops")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(tmpDir)
+
+	tokenFile := filepath.Join(tmpDir, "token")
+	writeToken := func(token string) {
+		t.Helper()
+		if err := ioutil.WriteFile(tokenFile, []byte(token), 0600); err != nil {
+			t.Fatal(err)
+		}
+	}
+	passwordOf := func(p AuthMethodProvider) string {
+		t.Helper()
+		m, err := p.AuthMethod(context.Background())
+		if err != nil {
+			t.Fatal(err)
+		}
+		return m.(*authMethod).AuthMethod.(*http.BasicAuth).Password
+	}
+
+	if _, err := NewHTTPSFileAuthMethodProvider("oauth2", tokenFile); err == nil {
+		t.Error("NewHTTPSFileAuthMethodProvider() with a missing file succeeded, want error")
+	}
+
+	writeToken("first\n")
+	p, err := NewHTTPSFileAuthMethodProvider("oauth2", tokenFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := passwordOf(p); got != "first" {
+		t.Errorf("password = %q, want %q", got, "first")
+	}
+
+	// The rotated token is picked up for the next operation
+	writeToken("second\n")
+	if got := passwordOf(p); got != "second" {
+		t.Errorf("password after rotation = %q, want %q", got, "second")
+	}
+
+	// Invalid credentials aren't used
+	writeToken("")
+	if _, err := p.AuthMethod(context.Background()); err == nil {
+		t.Error("AuthMethod() with an empty token succeeded, want error")
+	}
+}
@@ -6,15 +6,19 @@ import (
 	"fmt"
 	"io/ioutil"
 	"os"
-	"sync"
+	"path/filepath"
+	"strings"
 	"time"
 
 	"github.com/fluxcd/go-git-providers/gitprovider"
 	git "github.com/go-git/go-git/v5"
+	"github.com/go-git/go-git/v5/config"
 	"github.com/go-git/go-git/v5/plumbing"
 	"github.com/go-git/go-git/v5/plumbing/object"
+	"github.com/go-git/go-git/v5/plumbing/transport"
 	log "github.com/sirupsen/logrus"
-	"k8s.io/apimachinery/pkg/util/wait"
+	"golang.org/x/crypto/openpgp"
+	"k8s.io/apimachinery/pkg/util/version"
 )
 
 var (
@@ -23,6 +27,17 @@ var (
 	ErrNotStarted = errors.New("the gitDirectory hasn't been started (and hence, cloned) yet")
 	// ErrCannotWriteToReadOnly happens if you try to do a write operation for a non-authenticated Git repo.
 	ErrCannotWriteToReadOnly = errors.New("the gitDirectory is read-only, cannot write")
+	// ErrInvalidPath happens if one of GitDirectoryOptions.ScopePaths isn't a relative path inside the repository.
+	ErrInvalidPath = errors.New("the path must be relative to, and inside of, the repository")
+	// ErrRemoteMismatch happens if GitDirectoryOptions.Dir points to an existing clone of another repository.
+	ErrRemoteMismatch = errors.New("the existing clone's remote doesn't match the configured repository")
+	// ErrNoRepository happens if neither a repository ref nor GitDirectoryOptions.URL is given.
+	ErrNoRepository = errors.New("either a repository ref or a URL is required")
+	// ErrNoMatchingTag happens if no tag of the repository matches GitDirectoryOptions.TagRange.
+	ErrNoMatchingTag = errors.New("no tag matches the semver range")
+	// ErrBranchChanged happens if a branch checked out in a Worktree was moved, e.g. by a commit in another
+	// Worktree of the same branch, before the Worktree committed to it.
+	ErrBranchChanged = errors.New("the branch has changed since it was checked out")
 )
 
 const (
@@ -39,10 +54,81 @@ type GitDirectoryOptions struct {
 	Branch   string        // default "master"
 	Interval time.Duration // default 30s
 	Timeout  time.Duration // default 1m
-	// TODO: Support folder prefixes
+
+	// TagRange is an optional semver range of tags to follow instead of the main branch, e.g. ">=1.0.0 <2.0.0".
+	// The range consists of space- or comma-separated constraints with one of the operators =, !=, >, >=, <
+	// and <=. The highest matching tag, optionally prefixed with "v", is checked out. Pre-release tags are
+	// ignored. The gitDirectory is read-only when following tags.
+	TagRange string
+	// PinnedCommit is an optional full commit SHA to pin the checkout to instead of following the main branch.
+	// The gitDirectory is read-only when pinned to a commit.
+	PinnedCommit string
+
+	// Depth limits the clone to the given number of latest commits. Zero means the full history.
+	Depth int
+	// ScopePaths only scopes what consumers like the GitStorage read to the given subdirectories, relative
+	// to the repository root. The whole repository is still cloned, and all of its files are in Dir().
+	// If empty, the whole repository is considered.
+	ScopePaths []string
+
+	// RecurseSubmodules initializes and updates the submodules of the repository, recursively, when cloning
+	// and pulling, so that their files are part of the checkout. The same AuthMethod is used for them.
+	RecurseSubmodules bool
+
+	// Dir is an optional path to a persistent clone directory. If set, an existing clone
+	// in this directory is reused (and fetched incrementally) instead of cloning from scratch,
+	// and the directory is kept on Cleanup(). If unset, a temporary directory is used.
+	Dir string
+
+	// URL is an optional URL to clone from instead of the repository ref, e.g. a file:// URL or a path
+	// to a local (bare) repository, like an on-disk mirror. Local repositories are writable without an
+	// AuthMethod, which requires the git-upload-pack and git-receive-pack binaries to be installed.
+	// If unset, the clone URL of the repository ref for the transport type of AuthMethod is used,
+	// or HTTPS if AuthMethod is unset.
+	URL string
 
 	// Authentication
+
+	// AuthMethod is the static AuthMethod to use for all Git operations.
 	AuthMethod AuthMethod
+	// AuthMethodProvider is consulted for the AuthMethod before each Git operation, for credentials
+	// that are rotated. Only one of AuthMethod and AuthMethodProvider may be set.
+	AuthMethodProvider AuthMethodProvider
+
+	// Signing
+
+	// SignKey is an optional OpenPGP key with a decrypted private key, used to sign the commits created
+	// by Commit(). See NewSignKey for reading it from an armored private key.
+	SignKey *openpgp.Entity
+	// TrustedKeyRing is an optional armored OpenPGP keyring. If set, new commits on the main branch are
+	// only accepted if they are signed by any of the keys in it, otherwise ErrUntrustedCommit is returned.
+	TrustedKeyRing string
+
+	// LockObserver is optionally notified about how long each operation waited for the internal lock.
+	LockObserver LockObserver
+}
+
+func (o *GitDirectoryOptions) Validate() error {
+	if len(o.TagRange) != 0 && len(o.PinnedCommit) != 0 {
+		return errors.New("only one of TagRange and PinnedCommit may be set")
+	}
+	if len(o.TagRange) != 0 {
+		if _, err := parseSemverRange(o.TagRange); err != nil {
+			return err
+		}
+	}
+	if len(o.PinnedCommit) != 0 && !plumbing.IsHash(o.PinnedCommit) {
+		return fmt.Errorf("invalid commit %q: must be a full commit SHA", o.PinnedCommit)
+	}
+	if o.AuthMethod != nil && o.AuthMethodProvider != nil {
+		return errors.New("only one of AuthMethod and AuthMethodProvider may be set")
+	}
+	for _, p := range o.ScopePaths {
+		if filepath.IsAbs(p) || strings.HasPrefix(filepath.Clean(p), "..") {
+			return fmt.Errorf("invalid path %q: %w", p, ErrInvalidPath)
+		}
+	}
+	return nil
 }
 
 func (o *GitDirectoryOptions) Default() {
@@ -62,67 +148,159 @@ func (o *GitDirectoryOptions) Default() {
 // high-level access to write operations, like creating a new branch, committing,
 // and pushing.
 type GitDirectory interface {
-	// Dir returns the backing temporary directory of the git clone.
+	// Dir returns the backing directory of the git clone. Unless GitDirectoryOptions.Dir
+	// was set, this is a temporary directory.
 	Dir() string
-	// MainBranch returns the configured main branch.
+	// MainBranch returns the configured main branch. When following a tag range or commit, the main
+	// branch isn't checked out, and might not exist in the clone.
 	MainBranch() string
-	// RepositoryRef returns the repository reference.
+	// ScopePaths returns the subdirectories of the repository to consider, relative to Dir().
+	// If empty, the whole repository should be considered.
+	ScopePaths() []string
+	// SubmodulePaths returns the paths of the submodules of the checked out revision, relative to Dir().
+	// Nested submodules aren't included. Unless GitDirectoryOptions.RecurseSubmodules is set, the
+	// submodules aren't checked out.
+	// ErrNotStarted is returned if the repo hasn't been cloned yet.
+	SubmodulePaths() ([]string, error)
+	// RepositoryRef returns the repository reference. It's nil if only GitDirectoryOptions.URL was given.
 	RepositoryRef() gitprovider.RepositoryRef
 
 	// StartCheckoutLoop clones the repo synchronously, and then starts the checkout loop non-blocking.
 	// If the checkout loop has been started already, this is a no-op.
 	StartCheckoutLoop() error
-	// Suspend waits for any pending transactions or operations, and then locks the internal mutex so that
+	// Suspend waits for any pending transactions or operations, and then locks the internal lock so that
 	// no other operations can start. This means the periodic background checkout loop will momentarily stop.
-	Suspend()
-	// Resume unlocks the mutex locked in Suspend(), so that other Git operations, like the background checkout
+	// If ctx is done before the lock could be acquired, its error is returned, and Resume() must not be called.
+	Suspend(ctx context.Context) error
+	// Resume unlocks the lock locked in Suspend(), so that other Git operations, like the background checkout
 	// loop can resume its operation.
 	Resume()
+	// SuspendRead waits for any pending transactions or operations, and then locks the internal lock for
+	// reading, so that the worktree isn't modified until ResumeRead() is called. Other readers aren't blocked.
+	// If ctx is done before the lock could be acquired, its error is returned, and ResumeRead() must not be called.
+	SuspendRead(ctx context.Context) error
+	// ResumeRead unlocks the lock locked in SuspendRead().
+	ResumeRead()
 
 	// Pull performs a pull & checkout to the latest revision.
 	// ErrNotStarted is returned if the repo hasn't been cloned yet.
 	Pull(ctx context.Context) error
+	// Sync makes the checkout loop perform a pull & checkout immediately, instead of waiting for
+	// the interval to pass, and waits for it to finish. The interval is restarted afterwards.
+	// ErrNotStarted is returned if the repo hasn't been cloned yet.
+	Sync(ctx context.Context) error
+	// RequestSync asks the checkout loop to perform a pull & checkout as soon as possible, like Sync, but
+	// returns immediately. Requests made before the checkout loop gets to them are coalesced into one pull.
+	RequestSync()
 
 	// CheckoutNewBranch creates a new branch and checks out to it.
 	// ErrNotStarted is returned if the repo hasn't been cloned yet.
 	CheckoutNewBranch(branchName string) error
+	// CheckoutBranch checks out the given branch. If the branch exists on the remote and reset is false,
+	// it's fetched and checked out, so that new commits are added on top of it. Otherwise, the branch is
+	// (re-)created from the main branch. Whether the branch exists on the remote is returned.
+	// ErrNotStarted is returned if the repo hasn't been cloned yet.
+	CheckoutBranch(ctx context.Context, branchName string, reset bool) (bool, error)
+	// FetchBranch fetches the given branch from the remote without checking it out, and returns its latest commit
+	// SHA. The branch can then be read from the object database, e.g. with ReadFileAtRevision, using the SHA or the
+	// "origin/<branch>" revision. It waits for any pending operations, like Suspend().
+	// ErrNotStarted is returned if the repo hasn't been cloned yet.
+	FetchBranch(ctx context.Context, branchName string) (string, error)
 	// CheckoutMainBranch goes back to the main branch.
 	// ErrNotStarted is returned if the repo hasn't been cloned yet.
 	CheckoutMainBranch() error
+	// OpenWorktree checks out the given branch in a new, isolated Worktree, without changing Dir(). Like for
+	// CheckoutBranch, the branch is continued from if it exists on the remote and reset is false, otherwise
+	// it's (re-)created from the main branch. Whether the branch exists on the remote is returned.
+	// ErrNotStarted is returned if the repo hasn't been cloned yet.
+	// ErrCannotWriteToReadOnly is returned if no AuthMethod was provided for a remote repository.
+	OpenWorktree(ctx context.Context, branchName string, reset bool) (Worktree, bool, error)
 
-	// Commit creates a commit of all changes in the current worktree with the given parameters.
+	// Commit creates a commit of all changes in the current worktree as described by spec.
 	// It also automatically pushes the branch after the commit.
 	// ErrNotStarted is returned if the repo hasn't been cloned yet.
-	// ErrCannotWriteToReadOnly is returned if opts.AuthMethod wasn't provided.
-	Commit(ctx context.Context, authorName, authorEmail, msg string) error
-	// CommitChannel is a channel to where new observed Git SHAs are written.
-	CommitChannel() chan string
+	// ErrCannotWriteToReadOnly is returned if no AuthMethod was provided for a remote repository.
+	Commit(ctx context.Context, spec CommitSpec) error
+	// CommitChannel is a channel to where new observed commits of the main branch, or of the followed
+	// tag range or commit, are written, together with the files they changed.
+	CommitChannel() chan ObservedCommit
+
+	// The methods below read from the object database, which is modified when fetching. Hence, they wait for
+	// pending writing operations, like SuspendRead(), but don't block other readers, e.g. of the worktree.
+	// As waiting writers take precedence over new readers, they must not be called between SuspendRead()
+	// and ResumeRead(), as that would deadlock with a writer waiting in between.
+
+	// ResolveRevision resolves the given revision, e.g. a branch, tag or commit SHA, into a commit SHA.
+	// ErrNotStarted is returned if the repo hasn't been cloned yet.
+	ResolveRevision(rev string) (string, error)
+	// ListFilesAtRevision lists the paths of all files at the given revision, relative to the repository root.
+	// ErrNotStarted is returned if the repo hasn't been cloned yet.
+	ListFilesAtRevision(rev string) ([]string, error)
+	// ReadFileAtRevision returns the content of the file at the given revision. The path is relative to the
+	// repository root. If the file doesn't exist at the revision, object.ErrFileNotFound is returned.
+	// ErrNotStarted is returned if the repo hasn't been cloned yet.
+	ReadFileAtRevision(rev, path string) ([]byte, error)
+	// History returns the commits of the main branch that changed the file at the given path, newest first.
+	// The path is relative to the repository root. Renames of the file aren't followed.
+	// ErrNotStarted is returned if the repo hasn't been cloned yet.
+	History(path string) ([]Commit, error)
+	// Diff returns the files that changed between the from and to revisions. Renamed files are detected.
+	// ErrNotStarted is returned if the repo hasn't been cloned yet.
+	Diff(from, to string) ([]FileChange, error)
 
 	// Cleanup terminates any pending operations, and removes the temporary directory.
+	// A persistent directory given in GitDirectoryOptions.Dir is kept.
 	Cleanup() error
 }
 
 // Create a new GitDirectory implementation. In order to start using this, run StartCheckoutLoop().
+// repoRef may be nil if opts.URL is set, in which case RepositoryRef() also returns nil, and
+// the Git forge-specific functionality, like creating Pull Requests, is unavailable.
 func NewGitDirectory(repoRef gitprovider.RepositoryRef, opts GitDirectoryOptions) (GitDirectory, error) {
 	log.Info("Initializing the Git repo...")
 
-	// Default the options
+	// Default and validate the options
 	opts.Default()
-
-	// Create a temporary directory for the clone
-	tmpDir, err := ioutil.TempDir("", "libgitops")
-	if err != nil {
+	if err := opts.Validate(); err != nil {
 		return nil, err
 	}
-	log.Debugf("Created temporary directory for the git clone at %q", tmpDir)
+	if repoRef == nil && len(opts.URL) == 0 {
+		return nil, ErrNoRepository
+	}
+
+	// Use the persistent directory if given, otherwise create a temporary directory for the clone
+	cloneDir := opts.Dir
+	if len(cloneDir) != 0 {
+		if err := os.MkdirAll(cloneDir, 0755); err != nil {
+			return nil, err
+		}
+		log.Debugf("Using persistent directory for the git clone at %q", cloneDir)
+	} else {
+		tmpDir, err := ioutil.TempDir("", "libgitops")
+		if err != nil {
+			return nil, err
+		}
+		cloneDir = tmpDir
+		log.Debugf("Created temporary directory for the git clone at %q", cloneDir)
+	}
 
 	d := &gitDirectory{
 		repoRef:             repoRef,
 		GitDirectoryOptions: opts,
-		cloneDir:            tmpDir,
+		cloneDir:            cloneDir,
+		authProvider:        opts.AuthMethodProvider,
 		// TODO: This needs to be large, otherwise it can start blocking unnecessarily if nobody reads it
-		commitChan: make(chan string, 1024),
-		lock:       &sync.Mutex{},
+		commitChan:   make(chan ObservedCommit, 1024),
+		syncRequests: make(chan chan error),
+		syncPending:  make(chan struct{}, 1),
+		lock:         newRWLock(opts.LockObserver),
+	}
+	if opts.AuthMethod != nil {
+		d.authProvider = NewStaticAuthMethodProvider(opts.AuthMethod)
+	}
+	if len(opts.TagRange) != 0 {
+		// The range has already been validated
+		d.tagRange, _ = parseSemverRange(opts.TagRange)
 	}
 	// Set up the parent context for this class. d.cancel() is called only at Cleanup()
 	d.ctx, d.cancel = context.WithCancel(context.Background())
@@ -144,8 +322,12 @@ type gitDirectory struct {
 	repoRef gitprovider.RepositoryRef
 	GitDirectoryOptions
 
-	// the temporary directory used for the clone
+	// the directory used for the clone, temporary unless GitDirectoryOptions.Dir was set
 	cloneDir string
+	// provides the AuthMethod for each Git operation, nil if unauthenticated
+	authProvider AuthMethodProvider
+	// the parsed GitDirectoryOptions.TagRange, nil if not following tags
+	tagRange semverRange
 
 	// go-git objects. wt is the worktree of the repo, persistent during the lifetime of repo.
 	repo *git.Repository
@@ -154,13 +336,17 @@ type gitDirectory struct {
 	// latest known commit to the system
 	lastCommit string
 	// events channel from new commits
-	commitChan chan string
+	commitChan chan ObservedCommit
+	// requests for the checkout loop to pull immediately, the result is sent on the given channel
+	syncRequests chan chan error
+	// holds a signal if an asynchronous pull was requested, and the checkout loop didn't start it yet
+	syncPending chan struct{}
 
 	// the context and its cancel function for the lifetime of this struct (until Cleanup())
 	ctx    context.Context
 	cancel context.CancelFunc
 	// the lock for git operations (so pushing and pulling aren't done simultaneously)
-	lock *sync.Mutex
+	lock *rwLock
 }
 
 func (d *gitDirectory) Dir() string {
@@ -171,6 +357,10 @@ func (d *gitDirectory) MainBranch() string {
 	return d.Branch
 }
 
+func (d *gitDirectory) ScopePaths() []string {
+	return d.GitDirectoryOptions.ScopePaths
+}
+
 func (d *gitDirectory) RepositoryRef() gitprovider.RepositoryRef {
 	return d.repoRef
 }
@@ -189,40 +379,135 @@ func (d *gitDirectory) StartCheckoutLoop() error {
 	return nil
 }
 
-func (d *gitDirectory) Suspend() {
-	d.lock.Lock()
+func (d *gitDirectory) Suspend(ctx context.Context) error {
+	return d.lock.Lock(ctx, "suspend")
 }
 
 func (d *gitDirectory) Resume() {
 	d.lock.Unlock()
 }
 
-func (d *gitDirectory) CommitChannel() chan string {
+func (d *gitDirectory) SuspendRead(ctx context.Context) error {
+	return d.lock.RLock(ctx, "suspend-read")
+}
+
+func (d *gitDirectory) ResumeRead() {
+	d.lock.RUnlock()
+}
+
+func (d *gitDirectory) CommitChannel() chan ObservedCommit {
 	return d.commitChan
 }
 
 func (d *gitDirectory) checkoutLoop() {
 	log.Info("Starting the checkout loop...")
 
-	wait.NonSlidingUntilWithContext(d.ctx, func(_ context.Context) {
+	timer := time.NewTimer(d.Interval)
+	defer timer.Stop()
+	for {
+		// Wait for the interval to pass, or a request to sync immediately
+		var result chan error
+		select {
+		case <-d.ctx.Done():
+			log.Info("Exiting the checkout loop...")
+			return
+		case <-timer.C:
+		case result = <-d.syncRequests:
+			// Make sure the timer is stopped and drained before resetting it below
+			if !timer.Stop() {
+				<-timer.C
+			}
+		case <-d.syncPending:
+			if !timer.Stop() {
+				<-timer.C
+			}
+		}
 
 		log.Trace("checkoutLoop: Will perform pull operation")
 		// Perform a pull & checkout of the new revision
-		if err := d.Pull(d.ctx); err != nil {
+		err := d.Pull(d.ctx)
+		if err != nil {
 			log.Errorf("checkoutLoop: git pull failed with error: %v", err)
-			return
 		}
+		if result != nil {
+			result <- err
+		}
+		timer.Reset(d.Interval)
+	}
+}
+
+func (d *gitDirectory) Sync(ctx context.Context) error {
+	// Make sure it's okay to read
+	if err := d.verifyRead(); err != nil {
+		return err
+	}
+
+	// Ask the checkout loop to pull. The result channel is buffered, so the loop doesn't
+	// block even if ctx is cancelled before the result is received.
+	result := make(chan error, 1)
+	select {
+	case d.syncRequests <- result:
+	case <-ctx.Done():
+		return ctx.Err()
+	case <-d.ctx.Done():
+		return nil // if Cleanup() was called, there's nothing to sync
+	}
+
+	select {
+	case err := <-result:
+		return err
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
 
-	}, d.Interval)
-	log.Info("Exiting the checkout loop...")
+func (d *gitDirectory) RequestSync() {
+	// If a request is already pending, the pull will include the changes of this request too
+	select {
+	case d.syncPending <- struct{}{}:
+	default:
+	}
 }
 
 func (d *gitDirectory) cloneURL() string {
-	return d.repoRef.GetCloneURL(d.AuthMethod.TransportType())
+	if len(d.URL) != 0 {
+		return d.URL
+	}
+	if d.authProvider == nil {
+		return d.repoRef.GetCloneURL(gitprovider.TransportTypeHTTPS)
+	}
+	return d.repoRef.GetCloneURL(d.authProvider.TransportType())
+}
+
+// authMethod returns the current AuthMethod to use for a Git operation, or nil if unauthenticated
+func (d *gitDirectory) authMethod(ctx context.Context) (transport.AuthMethod, error) {
+	if d.authProvider == nil {
+		return nil, nil
+	}
+	authMethod, err := d.authProvider.AuthMethod(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get auth method: %w", err)
+	}
+	return authMethod, nil
+}
+
+// isLocal reports whether the repository is cloned from the local filesystem
+func (d *gitDirectory) isLocal() bool {
+	ep, err := transport.NewEndpoint(d.cloneURL())
+	return err == nil && ep.Protocol == "file"
+}
+
+func (d *gitDirectory) isPersistent() bool {
+	return len(d.GitDirectoryOptions.Dir) != 0
+}
+
+// tracksRevision reports whether a tag range or commit is followed instead of the main branch
+func (d *gitDirectory) tracksRevision() bool {
+	return d.tagRange != nil || len(d.PinnedCommit) != 0
 }
 
 func (d *gitDirectory) canWrite() bool {
-	return d.AuthMethod != nil
+	return (d.authProvider != nil || d.isLocal()) && !d.tracksRevision()
 }
 
 // verifyRead makes sure it's ok to start a read-something-from-git process
@@ -248,26 +533,75 @@ func (d *gitDirectory) verifyWrite() error {
 }
 
 func (d *gitDirectory) clone() error {
-	// Lock the mutex now that we're starting, and unlock it when exiting
-	d.lock.Lock()
+	// Lock the lock now that we're starting, and unlock it when exiting
+	if err := d.lock.Lock(d.ctx, "clone"); err != nil {
+		return err
+	}
 	defer d.lock.Unlock()
 
-	log.Infof("Starting to clone the repository %s with timeout %s", d.repoRef, d.Timeout)
-	// Do a clone operation to the temporary directory, with a timeout
-	err := d.contextWithTimeout(d.ctx, func(ctx context.Context) error {
+	// Reuse an existing clone in the persistent directory, if any
+	if d.isPersistent() {
+		repo, err := git.PlainOpen(d.Dir())
+		switch err {
+		case nil:
+			return d.reuse(repo)
+		case git.ErrRepositoryNotExists:
+			// no-op, clone into the directory below
+		default:
+			return fmt.Errorf("git open error: %v", err)
+		}
+	}
+
+	log.Infof("Starting to clone the repository %s with timeout %s", d.cloneURL(), d.Timeout)
+	// The clone is only checked out once the commit to check out is known to be trusted
+	var err error
+	if d.tracksRevision() {
+		err = d.fetchRepository()
+	} else {
+		err = d.cloneMainBranch()
+	}
+	if err == nil && d.repo == nil {
+		return nil // if Cleanup() was called, just exit the goroutine
+	}
+	if err == nil {
+		err = d.checkoutClone()
+	}
+
+	// Don't leave the clone behind if it can't be checked out, e.g. as its commit isn't trusted, so
+	// that it isn't mistaken for a usable checkout, and the clone is retried on the next start
+	if err != nil {
+		d.repo = nil
+		if rmErr := removeContents(d.Dir()); rmErr != nil {
+			log.Errorf("Failed to remove the clone in %q: %v", d.Dir(), rmErr)
+		}
+		return err
+	}
+	return nil
+}
+
+// cloneMainBranch clones the main branch into the clone directory without checking it out, with a timeout
+func (d *gitDirectory) cloneMainBranch() error {
+	auth, err := d.authMethod(d.ctx)
+	if err != nil {
+		return err
+	}
+	cloneOpts := &git.CloneOptions{
+		URL:           d.cloneURL(),
+		Auth:          auth,
+		RemoteName:    defaultRemote,
+		ReferenceName: plumbing.NewBranchReferenceName(d.Branch),
+		SingleBranch:  true,
+		NoCheckout:    true,
+		// Note: Shallow clones might not support all operations, ref: https://github.com/src-d/go-git/issues/1143
+		Depth:    d.Depth,
+		Progress: nil,
+		Tags:     git.NoTags,
+	}
+
+	// Do a clone operation to the clone directory, with a timeout
+	err = d.contextWithTimeout(d.ctx, func(ctx context.Context) error {
 		var err error
-		d.repo, err = git.PlainCloneContext(ctx, d.Dir(), false, &git.CloneOptions{
-			URL:           d.cloneURL(),
-			Auth:          d.AuthMethod,
-			RemoteName:    defaultRemote,
-			ReferenceName: plumbing.NewBranchReferenceName(d.Branch),
-			SingleBranch:  true,
-			NoCheckout:    false,
-			//Depth:             1, // ref: https://github.com/src-d/go-git/issues/1143
-			RecurseSubmodules: 0,
-			Progress:          nil,
-			Tags:              git.NoTags,
-		})
+		d.repo, err = git.PlainCloneContext(ctx, d.Dir(), false, cloneOpts)
 		return err
 	})
 	// Handle errors
@@ -278,43 +612,347 @@ func (d *gitDirectory) clone() error {
 		return fmt.Errorf("git clone operation took longer than deadline %s", d.Timeout)
 	case context.Canceled:
 		log.Tracef("context was cancelled")
-		return nil // if Cleanup() was called, just exit the goroutine
+		return nil
 	default:
 		return fmt.Errorf("git clone error: %v", err)
 	}
+	return nil
+}
+
+// fetchRepository initializes an empty repository in the clone directory, and fetches all branches and tags
+// into it, so that the followed tag range or commit can be resolved. Unlike cloning, this doesn't require
+// the main branch, or any default branch of the remote, to exist.
+func (d *gitDirectory) fetchRepository() error {
+	repo, err := git.PlainInit(d.Dir(), false)
+	if err != nil {
+		return fmt.Errorf("git init error: %v", err)
+	}
+	if _, err := repo.CreateRemote(&config.RemoteConfig{Name: defaultRemote, URLs: []string{d.cloneURL()}}); err != nil {
+		return fmt.Errorf("git create remote %q error: %v", defaultRemote, err)
+	}
+	d.repo = repo
+	if err := d.fetchAll(d.ctx); err != nil {
+		if d.ctx.Err() != nil {
+			log.Tracef("context was cancelled")
+			d.repo = nil
+			return nil
+		}
+		return err
+	}
+	return nil
+}
 
-	// Populate the worktree pointer
-	d.wt, err = d.repo.Worktree()
+// checkoutClone checks out the HEAD commit of the new clone, or the followed tag range or commit, if it can be
+// trusted, and only then populates the worktree pointer
+func (d *gitDirectory) checkoutClone() error {
+	wt, err := d.repo.Worktree()
 	if err != nil {
 		return fmt.Errorf("git get worktree error: %v", err)
 	}
+	if d.tracksRevision() {
+		if err := d.checkoutTrackedRevision(wt); err != nil {
+			return err
+		}
+		d.wt = wt
+		return nil
+	}
 
-	// Get the latest HEAD commit and report it to the user
+	// Get the latest HEAD commit, and make sure it can be trusted before checking it out
 	ref, err := d.repo.Head()
 	if err != nil {
 		return err
 	}
+	if err := d.verifyCommit(ref.Hash()); err != nil {
+		return err
+	}
+	if err := wt.Checkout(&git.CheckoutOptions{Branch: ref.Name(), Force: true}); err != nil {
+		return fmt.Errorf("git checkout error: %v", err)
+	}
+	if err := d.updateSubmodules(d.ctx, wt); err != nil {
+		return err
+	}
 
+	// Report the HEAD commit to the user
+	d.wt = wt
 	d.observeCommit(ref.Hash())
 	return nil
 }
 
+// removeContents removes everything in dir, but not dir itself
+func removeContents(dir string) error {
+	infos, err := ioutil.ReadDir(dir)
+	if err != nil {
+		return err
+	}
+	for _, info := range infos {
+		if err := os.RemoveAll(filepath.Join(dir, info.Name())); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// reuse validates an existing clone in the persistent directory, fetches the latest changes of
+// the main branch and resets the worktree to it. Leftovers from e.g. an interrupted transaction
+// are discarded, as all commits are pushed directly when made.
+func (d *gitDirectory) reuse(repo *git.Repository) error {
+	log.Infof("Reusing the existing clone of %s in %q", d.cloneURL(), d.Dir())
+
+	// Make sure the existing clone points to the same repository
+	remote, err := repo.Remote(defaultRemote)
+	if err != nil {
+		return fmt.Errorf("git get remote %q error: %v", defaultRemote, err)
+	}
+	if urls := remote.Config().URLs; len(urls) == 0 || urls[0] != d.cloneURL() {
+		return fmt.Errorf("%w: got %v, expected %q", ErrRemoteMismatch, urls, d.cloneURL())
+	}
+
+	d.repo = repo
+	wt, err := repo.Worktree()
+	if err != nil {
+		return fmt.Errorf("git get worktree error: %v", err)
+	}
+	if d.tracksRevision() {
+		if err := d.fetchAll(d.ctx); err != nil {
+			return err
+		}
+		if err := d.checkoutTrackedRevision(wt); err != nil {
+			return err
+		}
+		d.wt = wt
+		return nil
+	}
+
+	// Fetch the main branch incrementally. This fails if the branch doesn't exist.
+	hash, err := d.fetchBranch(d.ctx, d.Branch)
+	if err != nil {
+		return err
+	}
+	// Make sure the fetched commit can be trusted before checking it out
+	if err := d.verifyCommit(hash); err != nil {
+		return err
+	}
+
+	// Point the local main branch to the fetched commit, and force-checkout it
+	branchRef := plumbing.NewBranchReferenceName(d.Branch)
+	if err := repo.Storer.SetReference(plumbing.NewHashReference(branchRef, hash)); err != nil {
+		return fmt.Errorf("git set reference %q error: %v", branchRef, err)
+	}
+	if err := wt.Checkout(&git.CheckoutOptions{Branch: branchRef, Force: true}); err != nil {
+		return fmt.Errorf("git checkout error: %v", err)
+	}
+	// Best-effort clean
+	_ = wt.Clean(&git.CleanOptions{
+		Dir: true,
+	})
+	if err := d.updateSubmodules(d.ctx, wt); err != nil {
+		return err
+	}
+
+	d.wt = wt
+	d.observeCommit(hash)
+	return nil
+}
+
 func (d *gitDirectory) Pull(ctx context.Context) error {
-	// Lock the mutex now that we're starting, and unlock it when exiting
-	d.lock.Lock()
+	// Lock the lock now that we're starting, and unlock it when exiting
+	if err := d.lock.Lock(ctx, "pull"); err != nil {
+		if d.ctx.Err() != nil {
+			return nil // if Cleanup() was called, just exit the goroutine
+		}
+		return fmt.Errorf("cannot pull: %w", err)
+	}
 	defer d.lock.Unlock()
 
 	// Make sure it's okay to read
 	if err := d.verifyRead(); err != nil {
 		return err
 	}
+	// Fetch any new tags and resolve them, a pinned commit never changes
+	if d.tagRange != nil {
+		if err := d.fetchAll(ctx); err != nil {
+			return err
+		}
+		return d.checkoutTrackedRevision(d.wt)
+	} else if len(d.PinnedCommit) != 0 {
+		return nil
+	}
+
+	// Fetch the main branch without touching the worktree, so that an untrusted commit is never checked out
+	log.Trace("checkoutLoop: Starting pull operation")
+	hash, err := d.fetchBranch(ctx, d.Branch)
+	if err != nil {
+		if d.ctx.Err() != nil {
+			return nil // if Cleanup() was called, just exit the goroutine
+		}
+		return err
+	}
+
+	// check if we changed commits
+	if d.lastCommit == hash.String() {
+		return nil
+	}
+	// Refuse the new commit if it can't be trusted, the worktree stays at the last trusted commit
+	if err := d.verifyCommit(hash); err != nil {
+		return err
+	}
+	if err := d.fastForward(ctx, hash); err != nil {
+		return err
+	}
+	log.Trace("checkoutLoop: Pulled successfully")
+
+	// Notify upstream that we now have a new commit, and allow writing again
+	d.observeCommit(hash)
+	return nil
+}
+
+// fastForward moves the main branch, which must be checked out, to the given fetched commit, and updates
+// the worktree and its submodules. Like for git pull, the commit must descend from the checked out one.
+func (d *gitDirectory) fastForward(ctx context.Context, hash plumbing.Hash) error {
+	head, err := d.repo.Head()
+	if err != nil {
+		return err
+	}
+	headCommit, err := d.repo.CommitObject(head.Hash())
+	if err != nil {
+		return fmt.Errorf("git get commit %q error: %v", head.Hash(), err)
+	}
+	commit, err := d.repo.CommitObject(hash)
+	if err != nil {
+		return fmt.Errorf("git get commit %q error: %v", hash, err)
+	}
+	if ff, err := headCommit.IsAncestor(commit); err != nil {
+		return fmt.Errorf("failed to pull: %v", err)
+	} else if !ff {
+		return fmt.Errorf("failed to pull: %v", git.ErrNonFastForwardUpdate)
+	}
+
+	if err := d.wt.Reset(&git.ResetOptions{Commit: hash, Mode: git.MergeReset}); err != nil {
+		return fmt.Errorf("git reset error: %v", err)
+	}
+	return d.updateSubmodules(ctx, d.wt)
+}
+
+func (d *gitDirectory) CheckoutNewBranch(branchName string) error {
+	// Make sure it's okay to write
+	if err := d.verifyWrite(); err != nil {
+		return err
+	}
+
+	return d.wt.Checkout(&git.CheckoutOptions{
+		Branch: plumbing.NewBranchReferenceName(branchName),
+		Create: true,
+	})
+}
+
+func (d *gitDirectory) CheckoutBranch(ctx context.Context, branchName string, reset bool) (bool, error) {
+	// Make sure it's okay to write
+	if err := d.verifyWrite(); err != nil {
+		return false, err
+	}
+
+	exists, branchRef, err := d.prepareBranch(ctx, branchName, reset)
+	if err != nil {
+		return exists, err
+	}
+	if err := d.wt.Checkout(&git.CheckoutOptions{Branch: branchRef, Force: true}); err != nil {
+		return exists, fmt.Errorf("git checkout error: %v", err)
+	}
+	return exists, nil
+}
+
+// prepareBranch points the local branch to the commit to start from, overwriting any earlier local state.
+// If the branch exists on the remote and reset is false, it's fetched and continued from, otherwise the
+// branch starts from the main branch. Whether the branch exists on the remote is returned.
+func (d *gitDirectory) prepareBranch(ctx context.Context, branchName string, reset bool) (bool, plumbing.ReferenceName, error) {
+	branchRef := plumbing.NewBranchReferenceName(branchName)
+	exists, err := d.remoteBranchExists(ctx, branchName)
+	if err != nil {
+		return false, branchRef, err
+	}
+
+	var hash plumbing.Hash
+	if exists && !reset {
+		// Continue from the state of the branch on the remote
+		if hash, err = d.fetchBranch(ctx, branchName); err != nil {
+			return exists, branchRef, err
+		}
+	} else {
+		// Start from the main branch
+		ref, err := d.repo.Reference(plumbing.NewBranchReferenceName(d.Branch), true)
+		if err != nil {
+			return exists, branchRef, fmt.Errorf("git get reference %q error: %v", d.Branch, err)
+		}
+		hash = ref.Hash()
+	}
+
+	if err := d.repo.Storer.SetReference(plumbing.NewHashReference(branchRef, hash)); err != nil {
+		return exists, branchRef, fmt.Errorf("git set reference %q error: %v", branchRef, err)
+	}
+	return exists, branchRef, nil
+}
+
+// remoteBranchExists lists the references of the remote to check if the given branch exists
+func (d *gitDirectory) remoteBranchExists(ctx context.Context, branchName string) (bool, error) {
+	remote, err := d.repo.Remote(defaultRemote)
+	if err != nil {
+		return false, fmt.Errorf("git get remote %q error: %v", defaultRemote, err)
+	}
+	auth, err := d.authMethod(ctx)
+	if err != nil {
+		return false, err
+	}
+
+	// TODO: go-git doesn't support a context for listing remote references yet
+	refs, err := remote.List(&git.ListOptions{Auth: auth})
+	if err != nil {
+		return false, fmt.Errorf("git ls-remote error: %v", err)
+	}
+
+	branchRef := plumbing.NewBranchReferenceName(branchName)
+	for _, ref := range refs {
+		if ref.Name() == branchRef {
+			return true, nil
+		}
+	}
+	return false, nil
+}
+
+func (d *gitDirectory) FetchBranch(ctx context.Context, branchName string) (string, error) {
+	// Lock the lock now that we're starting, and unlock it when exiting
+	if err := d.lock.Lock(ctx, "fetch"); err != nil {
+		return "", fmt.Errorf("cannot fetch: %w", err)
+	}
+	defer d.lock.Unlock()
+
+	// Make sure it's okay to read
+	if err := d.verifyRead(); err != nil {
+		return "", err
+	}
+
+	hash, err := d.fetchBranch(ctx, branchName)
+	if err != nil {
+		return "", err
+	}
+	return hash.String(), nil
+}
 
-	// Perform the git pull operation using the timeout
-	err := d.contextWithTimeout(ctx, func(innerCtx context.Context) error {
-		log.Trace("checkoutLoop: Starting pull operation")
-		return d.wt.PullContext(innerCtx, &git.PullOptions{
-			Auth:         d.AuthMethod,
-			SingleBranch: true,
+// fetchBranch fetches the given branch from the remote into its remote-tracking branch, with
+// a timeout, and returns the fetched commit. This fails if the branch doesn't exist.
+func (d *gitDirectory) fetchBranch(ctx context.Context, branchName string) (plumbing.Hash, error) {
+	branchRef := plumbing.NewBranchReferenceName(branchName)
+	remoteRef := plumbing.NewRemoteReferenceName(defaultRemote, branchName)
+	auth, err := d.authMethod(ctx)
+	if err != nil {
+		return plumbing.ZeroHash, err
+	}
+	err = d.contextWithTimeout(ctx, func(innerCtx context.Context) error {
+		return d.repo.FetchContext(innerCtx, &git.FetchOptions{
+			RemoteName: defaultRemote,
+			RefSpecs:   []config.RefSpec{config.RefSpec(fmt.Sprintf("+%s:%s", branchRef, remoteRef))},
+			Depth:      d.Depth,
+			Auth:       auth,
+			Tags:       git.NoTags,
 		})
 	})
 	// Handle errors
@@ -322,41 +960,168 @@ func (d *gitDirectory) Pull(ctx context.Context) error {
 	case nil, git.NoErrAlreadyUpToDate:
 		// no-op, just continue. Allow the git.NoErrAlreadyUpToDate error
 	case context.DeadlineExceeded:
-		return fmt.Errorf("git pull operation took longer than deadline %s", d.Timeout)
-	case context.Canceled:
-		log.Tracef("context was cancelled")
-		return nil // if Cleanup() was called, just exit the goroutine
+		return plumbing.ZeroHash, fmt.Errorf("git fetch operation took longer than deadline %s", d.Timeout)
 	default:
-		return fmt.Errorf("failed to pull: %v", err)
+		return plumbing.ZeroHash, fmt.Errorf("git fetch error: %v", err)
 	}
 
-	log.Trace("checkoutLoop: Pulled successfully")
+	ref, err := d.repo.Reference(remoteRef, true)
+	if err != nil {
+		return plumbing.ZeroHash, fmt.Errorf("git get reference %q error: %v", remoteRef, err)
+	}
+	return ref.Hash(), nil
+}
 
-	// get current head
-	ref, err := d.repo.Head()
+func (d *gitDirectory) SubmodulePaths() ([]string, error) {
+	// Make sure it's okay to read
+	if err := d.verifyRead(); err != nil {
+		return nil, err
+	}
+
+	submodules, err := d.wt.Submodules()
 	if err != nil {
-		return err
+		return nil, fmt.Errorf("git list submodules error: %v", err)
 	}
+	paths := make([]string, 0, len(submodules))
+	for _, sm := range submodules {
+		paths = append(paths, sm.Config().Path)
+	}
+	return paths, nil
+}
 
-	// check if we changed commits
-	if d.lastCommit != ref.Hash().String() {
-		// Notify upstream that we now have a new commit, and allow writing again
-		d.observeCommit(ref.Hash())
+// submoduleRecursivity returns how deep submodules are recursed into when cloning and pulling
+func (d *gitDirectory) submoduleRecursivity() git.SubmoduleRescursivity {
+	if d.RecurseSubmodules {
+		return git.DefaultSubmoduleRecursionDepth
 	}
+	return git.NoRecurseSubmodules
+}
 
+// updateSubmodules initializes the submodules, if enabled, and checks out the commits recorded for them
+// in the given worktree, with a timeout
+func (d *gitDirectory) updateSubmodules(ctx context.Context, wt *git.Worktree) error {
+	if !d.RecurseSubmodules {
+		return nil
+	}
+	submodules, err := wt.Submodules()
+	if err != nil {
+		return fmt.Errorf("git list submodules error: %v", err)
+	}
+	auth, err := d.authMethod(ctx)
+	if err != nil {
+		return err
+	}
+	err = d.contextWithTimeout(ctx, func(innerCtx context.Context) error {
+		return submodules.UpdateContext(innerCtx, &git.SubmoduleUpdateOptions{
+			Init:              true,
+			RecurseSubmodules: d.submoduleRecursivity(),
+			Auth:              auth,
+		})
+	})
+	if err != nil {
+		return fmt.Errorf("git submodule update error: %v", err)
+	}
 	return nil
 }
 
-func (d *gitDirectory) CheckoutNewBranch(branchName string) error {
-	// Make sure it's okay to write
-	if err := d.verifyWrite(); err != nil {
+// fetchAll fetches all branches and tags from the remote, with a timeout
+func (d *gitDirectory) fetchAll(ctx context.Context) error {
+	auth, err := d.authMethod(ctx)
+	if err != nil {
 		return err
 	}
+	err = d.contextWithTimeout(ctx, func(innerCtx context.Context) error {
+		return d.repo.FetchContext(innerCtx, &git.FetchOptions{
+			RemoteName: defaultRemote,
+			RefSpecs: []config.RefSpec{
+				config.RefSpec(fmt.Sprintf("+refs/heads/*:refs/remotes/%s/*", defaultRemote)),
+				"+refs/tags/*:refs/tags/*",
+			},
+			Depth: d.Depth,
+			Auth:  auth,
+			Tags:  git.AllTags,
+		})
+	})
+	// Handle errors
+	switch err {
+	case nil, git.NoErrAlreadyUpToDate:
+		// no-op, just continue. Allow the git.NoErrAlreadyUpToDate error
+	case context.DeadlineExceeded:
+		return fmt.Errorf("git fetch operation took longer than deadline %s", d.Timeout)
+	default:
+		return fmt.Errorf("git fetch error: %v", err)
+	}
+	return nil
+}
 
-	return d.wt.Checkout(&git.CheckoutOptions{
-		Branch: plumbing.NewBranchReferenceName(branchName),
-		Create: true,
+// checkoutTrackedRevision resolves the followed tag range or commit, and checks out the resolved commit
+// in the given worktree if it changed and can be trusted
+func (d *gitDirectory) checkoutTrackedRevision(wt *git.Worktree) error {
+	hash, err := d.resolveTrackedRevision()
+	if err != nil {
+		return err
+	}
+	if hash.String() == d.lastCommit {
+		return nil
+	}
+	if err := d.verifyCommit(hash); err != nil {
+		return err
+	}
+	if err := wt.Checkout(&git.CheckoutOptions{Hash: hash, Force: true}); err != nil {
+		return fmt.Errorf("git checkout error: %v", err)
+	}
+	if err := d.updateSubmodules(d.ctx, wt); err != nil {
+		return err
+	}
+	d.observeCommit(hash)
+	return nil
+}
+
+// resolveTrackedRevision resolves the commit to check out, either the pinned commit, or the commit of the
+// highest tag matching the tag range
+func (d *gitDirectory) resolveTrackedRevision() (plumbing.Hash, error) {
+	if d.tagRange == nil {
+		hash := plumbing.NewHash(d.PinnedCommit)
+		if _, err := d.repo.CommitObject(hash); err != nil {
+			return plumbing.ZeroHash, fmt.Errorf("git get commit %q error: %w", hash, err)
+		}
+		return hash, nil
+	}
+
+	tags, err := d.repo.Tags()
+	if err != nil {
+		return plumbing.ZeroHash, fmt.Errorf("git list tags error: %v", err)
+	}
+	var latest *plumbing.Reference
+	var latestVersion *version.Version
+	_ = tags.ForEach(func(ref *plumbing.Reference) error {
+		v, err := version.ParseSemantic(ref.Name().Short())
+		if err != nil || len(v.PreRelease()) != 0 || !d.tagRange.matches(v) {
+			return nil // not a matching release tag
+		}
+		if latestVersion == nil || latestVersion.LessThan(v) {
+			latest, latestVersion = ref, v
+		}
+		return nil
 	})
+	if latest == nil {
+		return plumbing.ZeroHash, fmt.Errorf("%w: %q", ErrNoMatchingTag, d.TagRange)
+	}
+
+	// Annotated tags point to a tag object, lightweight tags directly to the commit
+	tag, err := d.repo.TagObject(latest.Hash())
+	switch err {
+	case nil:
+		commit, err := tag.Commit()
+		if err != nil {
+			return plumbing.ZeroHash, fmt.Errorf("git get commit of tag %q error: %v", latest.Name().Short(), err)
+		}
+		return commit.Hash, nil
+	case plumbing.ErrObjectNotFound:
+		return latest.Hash(), nil
+	default:
+		return plumbing.ZeroHash, fmt.Errorf("git get tag %q error: %v", latest.Name().Short(), err)
+	}
 }
 
 func (d *gitDirectory) CheckoutMainBranch() error {
@@ -376,51 +1141,170 @@ func (d *gitDirectory) CheckoutMainBranch() error {
 	})
 }
 
+// ObservedCommit describes a new commit that was checked out, see CommitChannel.
+type ObservedCommit struct {
+	Commit
+	// Previous is the SHA of the previously observed commit, or empty for the first observed commit.
+	Previous string
+	// ChangedFiles are the paths of the files that changed since Previous, relative to the repository root.
+	// For renamed files, both the old and the new path are included. ChangedFiles is nil if Previous is
+	// empty, or the changes couldn't be computed, in which case all files should be considered changed.
+	ChangedFiles []string
+}
+
 // observeCommit sets the lastCommit variable so that we know the latest state
 func (d *gitDirectory) observeCommit(commit plumbing.Hash) {
+	observed := d.newObservedCommit(d.lastCommit, commit)
 	d.lastCommit = commit.String()
-	d.commitChan <- commit.String()
-	log.Infof("New commit observed on branch %q: %s", d.Branch, commit)
+	d.commitChan <- observed
+	switch {
+	case d.tagRange != nil:
+		log.Infof("New commit observed for tag range %q: %s", d.TagRange, commit)
+	case len(d.PinnedCommit) != 0:
+		log.Infof("Checked out pinned commit %s", commit)
+	default:
+		log.Infof("New commit observed on branch %q: %s", d.Branch, commit)
+	}
+}
+
+// newObservedCommit describes the given commit, and the files changed since the previous commit, if any
+func (d *gitDirectory) newObservedCommit(previous string, commit plumbing.Hash) ObservedCommit {
+	observed := ObservedCommit{Commit: Commit{Hash: commit.String()}, Previous: previous}
+	if c, err := d.repo.CommitObject(commit); err == nil {
+		observed.Commit = newCommit(c)
+	} else {
+		log.Warnf("Failed to get observed commit %s: %v", commit, err)
+	}
+	if len(previous) == 0 || previous == commit.String() {
+		return observed
+	}
+
+	changes, err := d.diff(previous, commit.String())
+	if err != nil {
+		log.Warnf("Failed to compute the files changed since %s: %v", previous, err)
+		return observed
+	}
+	observed.ChangedFiles = make([]string, 0, len(changes))
+	for _, change := range changes {
+		if len(change.From) != 0 {
+			observed.ChangedFiles = append(observed.ChangedFiles, change.From)
+		}
+		if len(change.To) != 0 && change.To != change.From {
+			observed.ChangedFiles = append(observed.ChangedFiles, change.To)
+		}
+	}
+	return observed
+}
+
+// Signature identifies the author or committer of a commit.
+type Signature struct {
+	// Name of the person, as per git config.
+	Name string
+	// Email of the person, as per git config.
+	Email string
+	// When is the point in time of the signature. If zero, the time of the commit is used.
+	When time.Time
+}
+
+// CommitSpec describes a commit to be created.
+type CommitSpec struct {
+	// Author is the person who authored the changes.
+	Author Signature
+	// Committer is the person who created the commit. If nil, Author is used.
+	Committer *Signature
+	// Message is the full commit message, including any trailers.
+	Message string
+	// ForcePush overwrites the branch on the remote, even if the commit doesn't descend from it.
+	ForcePush bool
+}
+
+// toObjectSignature converts the Signature, defaulting When to now
+func (s Signature) toObjectSignature(now time.Time) *object.Signature {
+	when := s.When
+	if when.IsZero() {
+		when = now
+	}
+	return &object.Signature{Name: s.Name, Email: s.Email, When: when}
 }
 
-// Commit creates a commit of all changes in the current worktree with the given parameters.
+// Commit creates a commit of all changes in the current worktree as described by spec.
 // It also automatically pushes the branch after the commit.
 // ErrNotStarted is returned if the repo hasn't been cloned yet.
-// ErrCannotWriteToReadOnly is returned if opts.AuthMethod wasn't provided.
-func (d *gitDirectory) Commit(ctx context.Context, authorName, authorEmail, msg string) error {
+// ErrCannotWriteToReadOnly is returned if no AuthMethod was provided for a remote repository.
+func (d *gitDirectory) Commit(ctx context.Context, spec CommitSpec) error {
 	// Make sure it's okay to write
 	if err := d.verifyWrite(); err != nil {
 		return err
 	}
 
-	s, err := d.wt.Status()
+	head, err := d.commit(ctx, d.repo, d.wt, spec)
+	if err != nil || head == nil {
+		return err
+	}
+	// Only notify upstream about new commits on the main branch, not on e.g. transaction branches
+	if head.Name() == plumbing.NewBranchReferenceName(d.Branch) {
+		d.observeCommit(head.Hash())
+	}
+	return nil
+}
+
+// commit creates a commit of all changes in the given worktree of repo as described by spec, and pushes the
+// checked out branch. The new HEAD is returned, or nil if there was nothing to commit or Cleanup() was called.
+func (d *gitDirectory) commit(ctx context.Context, repo *git.Repository, wt *git.Worktree, spec CommitSpec) (*plumbing.Reference, error) {
+	s, err := wt.Status()
 	if err != nil {
-		return fmt.Errorf("git status failed: %v", err)
+		return nil, fmt.Errorf("git status failed: %v", err)
 	}
 	if s.IsClean() {
 		log.Debugf("No changed files in git repo, nothing to commit...")
-		return nil
+		return nil, nil
+	}
+
+	// Stage new files, as committing with All only includes changes to already tracked files
+	for file, fileStatus := range s {
+		if fileStatus.Worktree != git.Untracked {
+			continue
+		}
+		if _, err := wt.Add(file); err != nil {
+			return nil, fmt.Errorf("git add error: %v", err)
+		}
 	}
 
 	// Do a commit and push
 	log.Debug("commitLoop: Committing all local changes")
-	hash, err := d.wt.Commit(msg, &git.CommitOptions{
-		All: true,
-		Author: &object.Signature{
-			Name:  authorName,
-			Email: authorEmail,
-			When:  time.Now(),
-		},
+	committer := spec.Author
+	if spec.Committer != nil {
+		committer = *spec.Committer
+	}
+	now := time.Now()
+	hash, err := wt.Commit(spec.Message, &git.CommitOptions{
+		All:       true,
+		Author:    spec.Author.toObjectSignature(now),
+		Committer: committer.toObjectSignature(now),
+		SignKey:   d.SignKey,
 	})
 	if err != nil {
-		return fmt.Errorf("git commit error: %v", err)
+		return nil, fmt.Errorf("git commit error: %w", err)
+	}
+
+	head, err := repo.Head()
+	if err != nil {
+		return nil, err
 	}
 
-	// Perform the git push operation using the timeout
+	auth, err := d.authMethod(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	// Perform the git push operation of the current branch using the timeout
 	err = d.contextWithTimeout(ctx, func(innerCtx context.Context) error {
 		log.Debug("commitLoop: Will push with timeout")
-		return d.repo.PushContext(innerCtx, &git.PushOptions{
-			Auth: d.AuthMethod,
+		return repo.PushContext(innerCtx, &git.PushOptions{
+			RemoteName: defaultRemote,
+			RefSpecs:   []config.RefSpec{config.RefSpec(fmt.Sprintf("%s:%s", head.Name(), head.Name()))},
+			Auth:       auth,
+			Force:      spec.ForcePush,
 		})
 	})
 	// Handle errors
@@ -428,18 +1312,16 @@ func (d *gitDirectory) Commit(ctx context.Context, authorName, authorEmail, msg
 	case nil, git.NoErrAlreadyUpToDate:
 		// no-op, just continue. Allow the git.NoErrAlreadyUpToDate error
 	case context.DeadlineExceeded:
-		return fmt.Errorf("git push operation took longer than deadline %s", d.Timeout)
+		return nil, fmt.Errorf("git push operation took longer than deadline %s", d.Timeout)
 	case context.Canceled:
 		log.Tracef("context was cancelled")
-		return nil // if Cleanup() was called, just exit the goroutine
+		return nil, nil // if Cleanup() was called, just exit the goroutine
 	default:
-		return fmt.Errorf("failed to push: %v", err)
+		return nil, fmt.Errorf("failed to push: %v", err)
 	}
 
-	// Notify upstream that we now have a new commit, and allow writing again
 	log.Infof("A new commit with the actual state has been created and pushed to the origin: %q", hash)
-	d.observeCommit(hash)
-	return nil
+	return head, nil
 }
 
 func (d *gitDirectory) contextWithTimeout(ctx context.Context, fn func(context.Context) error) error {
@@ -465,6 +1347,11 @@ func (d *gitDirectory) Cleanup() error {
 	// Cancel the context for the two running goroutines, and any possible long-running operations
 	d.cancel()
 
+	// Keep the persistent directory, so it can be reused
+	if d.isPersistent() {
+		return nil
+	}
+
 	// Remove the temporary directory
 	if err := os.RemoveAll(d.Dir()); err != nil {
 		log.Errorf("Failed to clean up temp git directory: %v", err)
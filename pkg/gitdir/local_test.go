@@ -0,0 +1,651 @@
+package gitdir_test
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"testing"
+	"time"
+
+	git "github.com/go-git/go-git/v5"
+	"github.com/go-git/go-git/v5/plumbing"
+	"github.com/go-git/go-git/v5/plumbing/object"
+	"github.com/weaveworks/libgitops/pkg/gitdir"
+	"github.com/weaveworks/libgitops/pkg/storage/transaction/pullrequest/local"
+)
+
+func TestLocalRepository(t *testing.T) {
+	tmpDir, err := ioutil.TempDir("", "libgitops")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(tmpDir)
+
+	repoDir := filepath.Join(tmpDir, "repo.git")
+	if err := local.InitBareRepository(repoDir, "master", map[string][]byte{"a.yaml": []byte("a: 1")}); err != nil {
+		t.Fatal(err)
+	}
+
+	newGitDirectory := func() gitdir.GitDirectory {
+		d, err := gitdir.NewGitDirectory(nil, gitdir.GitDirectoryOptions{URL: repoDir, Interval: time.Hour})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := d.StartCheckoutLoop(); err != nil {
+			t.Fatal(err)
+		}
+		return d
+	}
+	d := newGitDirectory()
+	defer d.Cleanup()
+
+	if content, err := ioutil.ReadFile(filepath.Join(d.Dir(), "a.yaml")); err != nil || string(content) != "a: 1" {
+		t.Fatalf("a.yaml = %q, %v after clone, want %q", content, err, "a: 1")
+	}
+
+	// Commit to a new branch, and to the main branch through another clone
+	ctx := context.Background()
+	spec := gitdir.CommitSpec{
+		Author:  gitdir.Signature{Name: "Test", Email: "test@example.com"},
+		Message: "Update a",
+	}
+	commitFile := func(d gitdir.GitDirectory, content string) {
+		t.Helper()
+		if err := ioutil.WriteFile(filepath.Join(d.Dir(), "a.yaml"), []byte(content), 0644); err != nil {
+			t.Fatal(err)
+		}
+		if err := d.Commit(ctx, spec); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := d.CheckoutNewBranch("update-a"); err != nil {
+		t.Fatal(err)
+	}
+	commitFile(d, "a: 2")
+	if err := d.CheckoutMainBranch(); err != nil {
+		t.Fatal(err)
+	}
+
+	other := newGitDirectory()
+	defer other.Cleanup()
+	commitFile(other, "a: 3")
+
+	// The branch can be read without checking it out
+	if sha, err := other.FetchBranch(ctx, "update-a"); err != nil {
+		t.Fatal(err)
+	} else if content, err := other.ReadFileAtRevision(sha, "a.yaml"); err != nil || string(content) != "a: 2" {
+		t.Errorf("a.yaml = %q, %v at fetched branch, want %q", content, err, "a: 2")
+	}
+
+	// The new commit on the main branch is pulled
+	if err := d.Sync(ctx); err != nil {
+		t.Fatal(err)
+	}
+	if content, err := d.ReadFileAtRevision("master", "a.yaml"); err != nil || string(content) != "a: 3" {
+		t.Errorf("a.yaml = %q, %v after sync, want %q", content, err, "a: 3")
+	}
+	var observed gitdir.ObservedCommit
+	for len(d.CommitChannel()) != 0 {
+		observed = <-d.CommitChannel()
+	}
+	if len(observed.Previous) == 0 || len(observed.ChangedFiles) != 1 || observed.ChangedFiles[0] != "a.yaml" || observed.AuthorName != "Test" {
+		t.Errorf("observed commit = %+v after sync, want a.yaml changed by Test", observed)
+	}
+
+	// Continue from the pushed branch, or re-create it from the main branch
+	for _, tt := range []struct {
+		branch     string
+		reset      bool
+		wantExists bool
+		want       string
+	}{
+		{branch: "update-a", wantExists: true, want: "a: 2"},
+		{branch: "update-a", reset: true, wantExists: true, want: "a: 3"},
+		{branch: "update-b", wantExists: false, want: "a: 3"},
+	} {
+		exists, err := other.CheckoutBranch(ctx, tt.branch, tt.reset)
+		if err != nil {
+			t.Fatal(err)
+		}
+		content, err := ioutil.ReadFile(filepath.Join(other.Dir(), "a.yaml"))
+		if exists != tt.wantExists || err != nil || string(content) != tt.want {
+			t.Errorf("CheckoutBranch(%q, %t) = %t with a.yaml = %q, %v, want %t with %q",
+				tt.branch, tt.reset, exists, content, err, tt.wantExists, tt.want)
+		}
+		if err := other.CheckoutMainBranch(); err != nil {
+			t.Fatal(err)
+		}
+	}
+}
+
+func TestTrackRevision(t *testing.T) {
+	tmpDir, err := ioutil.TempDir("", "libgitops")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(tmpDir)
+
+	repoDir := filepath.Join(tmpDir, "repo.git")
+	if err := local.InitBareRepository(repoDir, "master", map[string][]byte{"a.yaml": []byte("a: 1")}); err != nil {
+		t.Fatal(err)
+	}
+	repo, err := git.PlainOpen(repoDir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// tagMaster tags the current commit of the main branch, and returns it
+	tagMaster := func(tag string, annotated bool) plumbing.Hash {
+		t.Helper()
+		ref, err := repo.Reference(plumbing.NewBranchReferenceName("master"), true)
+		if err != nil {
+			t.Fatal(err)
+		}
+		var opts *git.CreateTagOptions
+		if annotated {
+			opts = &git.CreateTagOptions{
+				Tagger:  &object.Signature{Name: "Test", Email: "test@example.com", When: time.Now()},
+				Message: "Release " + tag,
+			}
+		}
+		if _, err := repo.CreateTag(tag, ref.Hash(), opts); err != nil {
+			t.Fatal(err)
+		}
+		return ref.Hash()
+	}
+	newGitDirectory := func(opts gitdir.GitDirectoryOptions) (gitdir.GitDirectory, error) {
+		opts.URL, opts.Interval = repoDir, time.Hour
+		d, err := gitdir.NewGitDirectory(nil, opts)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return d, d.StartCheckoutLoop()
+	}
+	readFile := func(d gitdir.GitDirectory) string {
+		t.Helper()
+		content, err := ioutil.ReadFile(filepath.Join(d.Dir(), "a.yaml"))
+		if err != nil {
+			t.Fatal(err)
+		}
+		return string(content)
+	}
+
+	first := tagMaster("v1.0.0", true)
+	d, err := newGitDirectory(gitdir.GitDirectoryOptions{TagRange: ">=1.0.0 <2.0.0"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer d.Cleanup()
+	if got := readFile(d); got != "a: 1" {
+		t.Errorf("a.yaml = %q after clone, want %q", got, "a: 1")
+	}
+	if err := d.CheckoutNewBranch("foo"); !errors.Is(err, gitdir.ErrCannotWriteToReadOnly) {
+		t.Errorf("CheckoutNewBranch() when following tags error = %v, want ErrCannotWriteToReadOnly", err)
+	}
+
+	// Release new versions through another clone, only the highest tag in the range is checked out
+	writer, err := newGitDirectory(gitdir.GitDirectoryOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer writer.Cleanup()
+	ctx := context.Background()
+	for _, tag := range []string{"v1.1.0", "v1.2.0-rc.1", "v2.0.0"} {
+		if err := ioutil.WriteFile(filepath.Join(writer.Dir(), "a.yaml"), []byte("a: "+tag), 0644); err != nil {
+			t.Fatal(err)
+		}
+		if err := writer.Commit(ctx, gitdir.CommitSpec{
+			Author:  gitdir.Signature{Name: "Test", Email: "test@example.com"},
+			Message: "Release " + tag,
+		}); err != nil {
+			t.Fatal(err)
+		}
+		tagMaster(tag, false)
+	}
+	if err := d.Sync(ctx); err != nil {
+		t.Fatal(err)
+	}
+	if got := readFile(d); got != "a: v1.1.0" {
+		t.Errorf("a.yaml = %q after sync, want %q", got, "a: v1.1.0")
+	}
+
+	// A pinned commit is checked out regardless of newer commits
+	pinned, err := newGitDirectory(gitdir.GitDirectoryOptions{PinnedCommit: first.String()})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer pinned.Cleanup()
+	if got := readFile(pinned); got != "a: 1" {
+		t.Errorf("a.yaml = %q at pinned commit, want %q", got, "a: 1")
+	}
+
+	none, err := newGitDirectory(gitdir.GitDirectoryOptions{TagRange: ">=3.0.0"})
+	defer none.Cleanup()
+	if !errors.Is(err, gitdir.ErrNoMatchingTag) {
+		t.Errorf("StartCheckoutLoop() without a matching tag error = %v, want ErrNoMatchingTag", err)
+	}
+
+	// Tags and commits are followed regardless of the main branch, which doesn't exist in this repository
+	mainRepoDir := filepath.Join(tmpDir, "main.git")
+	if err := local.InitBareRepository(mainRepoDir, "main", map[string][]byte{"a.yaml": []byte("a: main")}); err != nil {
+		t.Fatal(err)
+	}
+	mainRepo, err := git.PlainOpen(mainRepoDir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	mainRef, err := mainRepo.Reference(plumbing.NewBranchReferenceName("main"), true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := mainRepo.CreateTag("v1.0.0", mainRef.Hash(), nil); err != nil {
+		t.Fatal(err)
+	}
+	for name, opts := range map[string]gitdir.GitDirectoryOptions{
+		"tag range":     {TagRange: ">=1.0.0"},
+		"pinned commit": {PinnedCommit: mainRef.Hash().String()},
+	} {
+		opts.URL, opts.Interval = mainRepoDir, time.Hour
+		d, err := gitdir.NewGitDirectory(nil, opts)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer d.Cleanup()
+		if err := d.StartCheckoutLoop(); err != nil {
+			t.Errorf("StartCheckoutLoop() with a %s on a repository without %q error = %v", name, d.MainBranch(), err)
+			continue
+		}
+		if got := readFile(d); got != "a: main" {
+			t.Errorf("a.yaml = %q with a %s, want %q", got, name, "a: main")
+		}
+	}
+}
+
+func TestWorktree(t *testing.T) {
+	tmpDir, err := ioutil.TempDir("", "libgitops")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(tmpDir)
+
+	repoDir := filepath.Join(tmpDir, "repo.git")
+	if err := local.InitBareRepository(repoDir, "master", map[string][]byte{"a.yaml": []byte("a: 1")}); err != nil {
+		t.Fatal(err)
+	}
+	d, err := gitdir.NewGitDirectory(nil, gitdir.GitDirectoryOptions{URL: repoDir, Interval: time.Hour})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := d.StartCheckoutLoop(); err != nil {
+		t.Fatal(err)
+	}
+	defer d.Cleanup()
+	ctx := context.Background()
+
+	// Modify two branches at the same time in separate worktrees
+	worktrees := map[string]gitdir.Worktree{}
+	for _, branch := range []string{"update-a", "update-b"} {
+		wt, exists, err := d.OpenWorktree(ctx, branch, false)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer wt.Cleanup()
+		if exists {
+			t.Errorf("OpenWorktree(%q) reported an existing branch", branch)
+		}
+		if err := ioutil.WriteFile(filepath.Join(wt.Dir(), "a.yaml"), []byte("a: "+branch), 0644); err != nil {
+			t.Fatal(err)
+		}
+		worktrees[branch] = wt
+	}
+	for branch, wt := range worktrees {
+		if err := wt.Commit(ctx, gitdir.CommitSpec{
+			Author:  gitdir.Signature{Name: "Test", Email: "test@example.com"},
+			Message: "Update a on " + branch,
+		}); err != nil {
+			t.Fatal(err)
+		}
+		sha, err := d.FetchBranch(ctx, branch)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if content, err := d.ReadFileAtRevision(sha, "a.yaml"); err != nil || string(content) != "a: "+branch {
+			t.Errorf("a.yaml = %q, %v on branch %q, want %q", content, err, branch, "a: "+branch)
+		}
+	}
+
+	// Of two worktrees of the same branch, only the first one can commit, as the second one would discard
+	// the changes of the first one
+	first, _, err := d.OpenWorktree(ctx, "update-a", false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer first.Cleanup()
+	second, _, err := d.OpenWorktree(ctx, "update-a", false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer second.Cleanup()
+	for file, wt := range map[string]gitdir.Worktree{"b.yaml": first, "c.yaml": second} {
+		if err := ioutil.WriteFile(filepath.Join(wt.Dir(), file), []byte("created: true"), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	spec := gitdir.CommitSpec{
+		Author:  gitdir.Signature{Name: "Test", Email: "test@example.com"},
+		Message: "Create a file",
+	}
+	if err := first.Commit(ctx, spec); err != nil {
+		t.Fatal(err)
+	}
+	if err := second.Commit(ctx, spec); !errors.Is(err, gitdir.ErrBranchChanged) {
+		t.Errorf("Commit() of a changed branch error = %v, want ErrBranchChanged", err)
+	}
+	sha, err := d.FetchBranch(ctx, "update-a")
+	if err != nil {
+		t.Fatal(err)
+	}
+	files, err := d.ListFilesAtRevision(sha)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if fmt.Sprint(files) != "[a.yaml b.yaml]" {
+		t.Errorf("files = %v on branch %q, want [a.yaml b.yaml]", files, "update-a")
+	}
+
+	// The main worktree still has the main branch checked out
+	if content, err := ioutil.ReadFile(filepath.Join(d.Dir(), "a.yaml")); err != nil || string(content) != "a: 1" {
+		t.Errorf("a.yaml = %q, %v in the main worktree, want %q", content, err, "a: 1")
+	}
+	if head, err := d.ResolveRevision("HEAD"); err != nil {
+		t.Fatal(err)
+	} else if master, _ := d.ResolveRevision("master"); head != master {
+		t.Errorf("HEAD = %s, want the main branch at %s", head, master)
+	}
+}
+
+// TestConcurrentReads reads from the object database while pulling, run it with -race
+func TestConcurrentReads(t *testing.T) {
+	tmpDir, err := ioutil.TempDir("", "libgitops")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(tmpDir)
+
+	repoDir := filepath.Join(tmpDir, "repo.git")
+	if err := local.InitBareRepository(repoDir, "master", map[string][]byte{"a.yaml": []byte("a: 0")}); err != nil {
+		t.Fatal(err)
+	}
+	newGitDirectory := func() gitdir.GitDirectory {
+		d, err := gitdir.NewGitDirectory(nil, gitdir.GitDirectoryOptions{URL: repoDir, Interval: time.Hour})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := d.StartCheckoutLoop(); err != nil {
+			t.Fatal(err)
+		}
+		return d
+	}
+	d, writer := newGitDirectory(), newGitDirectory()
+	defer d.Cleanup()
+	defer writer.Cleanup()
+	first, err := d.ResolveRevision("HEAD")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	done := make(chan struct{})
+	readErr := make(chan error, 1)
+	go func() {
+		defer close(readErr)
+		for {
+			select {
+			case <-done:
+				return
+			default:
+			}
+			if _, err := d.Diff(first, "master"); err != nil {
+				readErr <- err
+				return
+			}
+			if _, err := d.ReadFileAtRevision("master", "a.yaml"); err != nil {
+				readErr <- err
+				return
+			}
+		}
+	}()
+
+	ctx := context.Background()
+	for i := 1; i <= 5; i++ {
+		if err := ioutil.WriteFile(filepath.Join(writer.Dir(), "a.yaml"), []byte(fmt.Sprintf("a: %d", i)), 0644); err != nil {
+			t.Fatal(err)
+		}
+		if err := writer.Commit(ctx, gitdir.CommitSpec{
+			Author:  gitdir.Signature{Name: "Test", Email: "test@example.com"},
+			Message: "Update a",
+		}); err != nil {
+			t.Fatal(err)
+		}
+		if err := d.Pull(ctx); err != nil {
+			t.Fatal(err)
+		}
+	}
+	close(done)
+	if err := <-readErr; err != nil {
+		t.Errorf("read while pulling: %v", err)
+	}
+	if changes, err := d.Diff(first, "master"); err != nil || len(changes) != 1 {
+		t.Errorf("Diff() = %v, %v after pulling, want a.yaml changed", changes, err)
+	}
+}
+
+func TestSubmodules(t *testing.T) {
+	tmpDir, err := ioutil.TempDir("", "libgitops")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(tmpDir)
+
+	baseDir, repoDir, workDir := filepath.Join(tmpDir, "base.git"), filepath.Join(tmpDir, "repo.git"), filepath.Join(tmpDir, "work")
+	if err := local.InitBareRepository(baseDir, "master", map[string][]byte{"b.yaml": []byte("b: 1")}); err != nil {
+		t.Fatal(err)
+	}
+	if err := local.InitBareRepository(repoDir, "master", map[string][]byte{"a.yaml": []byte("a: 1")}); err != nil {
+		t.Fatal(err)
+	}
+
+	// go-git can't add submodules, hence use the git CLI, which is required for local repositories anyways
+	runGit := func(args ...string) {
+		t.Helper()
+		cmd := exec.Command("git", append([]string{
+			"-c", "user.name=Test", "-c", "user.email=test@example.com", "-c", "protocol.file.allow=always",
+		}, args...)...)
+		cmd.Dir = workDir
+		if out, err := cmd.CombinedOutput(); err != nil {
+			t.Fatalf("git %v: %v: %s", args, err, out)
+		}
+	}
+	if err := os.Mkdir(workDir, 0755); err != nil {
+		t.Fatal(err)
+	}
+	runGit("clone", repoDir, ".")
+	runGit("submodule", "add", baseDir, "base")
+	runGit("commit", "-m", "Add base")
+	runGit("push", "origin", "master")
+
+	for _, recurse := range []bool{false, true} {
+		d, err := gitdir.NewGitDirectory(nil, gitdir.GitDirectoryOptions{URL: repoDir, Interval: time.Hour, RecurseSubmodules: recurse})
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer d.Cleanup()
+		if err := d.StartCheckoutLoop(); err != nil {
+			t.Fatal(err)
+		}
+
+		if paths, err := d.SubmodulePaths(); err != nil || len(paths) != 1 || paths[0] != "base" {
+			t.Errorf("SubmodulePaths() = %v, %v, want [base]", paths, err)
+		}
+		content, err := ioutil.ReadFile(filepath.Join(d.Dir(), "base", "b.yaml"))
+		if recurse && (err != nil || string(content) != "b: 1") {
+			t.Errorf("base/b.yaml = %q, %v with RecurseSubmodules, want %q", content, err, "b: 1")
+		} else if !recurse && !os.IsNotExist(err) {
+			t.Errorf("base/b.yaml = %q, %v without RecurseSubmodules, want it to not exist", content, err)
+		}
+	}
+}
+
+func TestPersistentDirectory(t *testing.T) {
+	tmpDir, err := ioutil.TempDir("", "libgitops")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(tmpDir)
+
+	repoDir, otherRepoDir := filepath.Join(tmpDir, "repo.git"), filepath.Join(tmpDir, "other.git")
+	for _, dir := range []string{repoDir, otherRepoDir} {
+		if err := local.InitBareRepository(dir, "master", map[string][]byte{"a.yaml": []byte("a: 1")}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	cloneDir := filepath.Join(tmpDir, "clone")
+	newGitDirectory := func(url, dir string) (gitdir.GitDirectory, error) {
+		d, err := gitdir.NewGitDirectory(nil, gitdir.GitDirectoryOptions{URL: url, Dir: dir, Interval: time.Hour})
+		if err != nil {
+			t.Fatal(err)
+		}
+		return d, d.StartCheckoutLoop()
+	}
+	readFile := func(file string) string {
+		t.Helper()
+		content, err := ioutil.ReadFile(file)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return string(content)
+	}
+
+	// The persistent directory is kept on Cleanup, together with any leftovers
+	d, err := newGitDirectory(repoDir, cloneDir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if d.Dir() != cloneDir {
+		t.Errorf("Dir() = %q, want %q", d.Dir(), cloneDir)
+	}
+	leftover := filepath.Join(cloneDir, "leftover.yaml")
+	if err := ioutil.WriteFile(leftover, []byte("b: 1"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := d.Cleanup(); err != nil {
+		t.Fatal(err)
+	}
+	if got := readFile(filepath.Join(cloneDir, "a.yaml")); got != "a: 1" {
+		t.Errorf("a.yaml = %q after Cleanup(), want %q", got, "a: 1")
+	}
+
+	// Commit through a temporary clone, which is removed on Cleanup
+	writer, err := newGitDirectory(repoDir, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := ioutil.WriteFile(filepath.Join(writer.Dir(), "a.yaml"), []byte("a: 2"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := writer.Commit(context.Background(), gitdir.CommitSpec{
+		Author:  gitdir.Signature{Name: "Test", Email: "test@example.com"},
+		Message: "Update a",
+	}); err != nil {
+		t.Fatal(err)
+	}
+	if err := writer.Cleanup(); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := os.Stat(writer.Dir()); !os.IsNotExist(err) {
+		t.Errorf("the temporary directory still exists after Cleanup(): %v", err)
+	}
+
+	// The existing clone is reused and updated to the latest commit, discarding the leftovers
+	reused, err := newGitDirectory(repoDir, cloneDir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer reused.Cleanup()
+	if got := readFile(filepath.Join(cloneDir, "a.yaml")); got != "a: 2" {
+		t.Errorf("a.yaml = %q after reusing the clone, want %q", got, "a: 2")
+	}
+	if _, err := os.Stat(leftover); !os.IsNotExist(err) {
+		t.Errorf("the leftover file still exists after reusing the clone: %v", err)
+	}
+
+	// A clone of another repository isn't reused
+	other, err := newGitDirectory(otherRepoDir, cloneDir)
+	defer other.Cleanup()
+	if !errors.Is(err, gitdir.ErrRemoteMismatch) {
+		t.Errorf("StartCheckoutLoop() with a clone of another repository error = %v, want ErrRemoteMismatch", err)
+	}
+}
+
+func TestShallowClone(t *testing.T) {
+	tmpDir, err := ioutil.TempDir("", "libgitops")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(tmpDir)
+
+	repoDir := filepath.Join(tmpDir, "repo.git")
+	if err := local.InitBareRepository(repoDir, "master", map[string][]byte{"a.yaml": []byte("a: 1")}); err != nil {
+		t.Fatal(err)
+	}
+	newGitDirectory := func(depth int) gitdir.GitDirectory {
+		t.Helper()
+		d, err := gitdir.NewGitDirectory(nil, gitdir.GitDirectoryOptions{URL: repoDir, Interval: time.Hour, Depth: depth})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := d.StartCheckoutLoop(); err != nil {
+			t.Fatal(err)
+		}
+		return d
+	}
+
+	// Create a history of four commits changing a.yaml
+	writer := newGitDirectory(0)
+	defer writer.Cleanup()
+	for i := 2; i <= 4; i++ {
+		if err := ioutil.WriteFile(filepath.Join(writer.Dir(), "a.yaml"), []byte(fmt.Sprintf("a: %d", i)), 0644); err != nil {
+			t.Fatal(err)
+		}
+		if err := writer.Commit(context.Background(), gitdir.CommitSpec{
+			Author:  gitdir.Signature{Name: "Test", Email: "test@example.com"},
+			Message: "Update a",
+		}); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	for _, tt := range []struct {
+		depth int
+		want  int
+	}{
+		{depth: 0, want: 4},
+		{depth: 1, want: 1},
+		{depth: 2, want: 2},
+	} {
+		d := newGitDirectory(tt.depth)
+		defer d.Cleanup()
+		// The parents of the oldest commit of a shallow clone are missing
+		got := 0
+		for ; got <= 4; got++ {
+			if _, err := d.ResolveRevision(fmt.Sprintf("HEAD~%d", got)); err != nil {
+				break
+			}
+		}
+		if got != tt.want {
+			t.Errorf("the clone with depth %d has %d commits, want %d", tt.depth, got, tt.want)
+		}
+	}
+}
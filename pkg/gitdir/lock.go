@@ -0,0 +1,124 @@
+package gitdir
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+)
+
+// slowLockWait is the wait time after which acquiring the lock is logged
+const slowLockWait = 1 * time.Second
+
+// LockObserver is notified about how long an operation waited for the lock of the gitDirectory,
+// e.g. to export it as a metric. write is false for operations that only read the worktree.
+// If the wait was aborted, because the context was cancelled, err is non-nil.
+type LockObserver func(operation string, write bool, wait time.Duration, err error)
+
+// rwLock is a readers-writer lock whose acquisition can be aborted through a context. Waiting
+// writers take precedence over new readers, so that a steady stream of readers can't starve them.
+type rwLock struct {
+	mu             sync.Mutex
+	readers        int
+	writer         bool
+	waitingWriters int
+	// released is closed, and replaced, whenever the state changes so that waiters can retry
+	released chan struct{}
+
+	observer LockObserver
+}
+
+func newRWLock(observer LockObserver) *rwLock {
+	return &rwLock{released: make(chan struct{}), observer: observer}
+}
+
+// Lock acquires the lock for writing, or returns the context error if ctx is done first.
+func (l *rwLock) Lock(ctx context.Context, operation string) error {
+	return l.acquire(ctx, operation, true)
+}
+
+// RLock acquires the lock for reading, or returns the context error if ctx is done first.
+func (l *rwLock) RLock(ctx context.Context, operation string) error {
+	return l.acquire(ctx, operation, false)
+}
+
+// Unlock releases the lock acquired with Lock.
+func (l *rwLock) Unlock() {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if !l.writer {
+		panic("gitdir: Unlock of unlocked rwLock")
+	}
+	l.writer = false
+	l.notify()
+}
+
+// RUnlock releases the lock acquired with RLock.
+func (l *rwLock) RUnlock() {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if l.readers == 0 {
+		panic("gitdir: RUnlock of unlocked rwLock")
+	}
+	l.readers--
+	if l.readers == 0 {
+		l.notify()
+	}
+}
+
+func (l *rwLock) acquire(ctx context.Context, operation string, write bool) error {
+	start := time.Now()
+	err := l.wait(ctx, write)
+
+	wait := time.Since(start)
+	if wait > slowLockWait {
+		log.Debugf("Operation %q waited %s for the git lock (write: %t, error: %v)", operation, wait, write, err)
+	}
+	if l.observer != nil {
+		l.observer(operation, write, wait, err)
+	}
+	return err
+}
+
+func (l *rwLock) wait(ctx context.Context, write bool) error {
+	l.mu.Lock()
+	if write {
+		l.waitingWriters++
+	}
+	for {
+		if write && !l.writer && l.readers == 0 {
+			l.waitingWriters--
+			l.writer = true
+			l.mu.Unlock()
+			return nil
+		}
+		if !write && !l.writer && l.waitingWriters == 0 {
+			l.readers++
+			l.mu.Unlock()
+			return nil
+		}
+
+		released := l.released
+		l.mu.Unlock()
+		select {
+		case <-released:
+			l.mu.Lock()
+		case <-ctx.Done():
+			l.mu.Lock()
+			if write {
+				// Readers might be waiting for this writer only
+				l.waitingWriters--
+				l.notify()
+			}
+			l.mu.Unlock()
+			return ctx.Err()
+		}
+	}
+}
+
+// notify wakes up all waiters, l.mu must be held
+func (l *rwLock) notify() {
+	close(l.released)
+	l.released = make(chan struct{})
+}
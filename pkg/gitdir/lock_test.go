@@ -0,0 +1,67 @@
+package gitdir
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+)
+
+func TestRWLock(t *testing.T) {
+	var observed []string
+	l := newRWLock(func(operation string, _ bool, _ time.Duration, err error) {
+		if err != nil {
+			operation += " aborted"
+		}
+		observed = append(observed, operation)
+	})
+	ctx := context.Background()
+	// timeout returns a context which is done shortly, for acquisitions that are expected to block
+	timeout := func() context.Context {
+		ctx, cancel := context.WithTimeout(ctx, 50*time.Millisecond)
+		t.Cleanup(cancel)
+		return ctx
+	}
+
+	// Readers don't block each other, but block writers
+	if err := l.RLock(ctx, "read-1"); err != nil {
+		t.Fatal(err)
+	}
+	if err := l.RLock(ctx, "read-2"); err != nil {
+		t.Fatal(err)
+	}
+	if err := l.Lock(timeout(), "write-1"); !errors.Is(err, context.DeadlineExceeded) {
+		t.Errorf("Lock() while read-locked error = %v, want DeadlineExceeded", err)
+	}
+
+	// A waiting writer blocks new readers, and acquires the lock once all readers are done
+	locked := make(chan error)
+	go func() { locked <- l.Lock(ctx, "write-2") }()
+	time.Sleep(10 * time.Millisecond)
+	if err := l.RLock(timeout(), "read-3"); !errors.Is(err, context.DeadlineExceeded) {
+		t.Errorf("RLock() with a waiting writer error = %v, want DeadlineExceeded", err)
+	}
+	l.RUnlock()
+	l.RUnlock()
+	if err := <-locked; err != nil {
+		t.Fatal(err)
+	}
+
+	// Readers can continue when the writer is done
+	l.Unlock()
+	if err := l.RLock(timeout(), "read-4"); err != nil {
+		t.Errorf("RLock() after Unlock() error = %v", err)
+	}
+	l.RUnlock()
+
+	want := []string{"read-1", "read-2", "write-1 aborted", "read-3 aborted", "write-2", "read-4"}
+	if len(observed) != len(want) {
+		t.Fatalf("observed %v, want %v", observed, want)
+	}
+	for i := range want {
+		if observed[i] != want[i] {
+			t.Errorf("observed %v, want %v", observed, want)
+			break
+		}
+	}
+}
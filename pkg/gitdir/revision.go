@@ -0,0 +1,207 @@
+package gitdir
+
+import (
+	"fmt"
+	"io"
+	"io/ioutil"
+	"time"
+
+	git "github.com/go-git/go-git/v5"
+	"github.com/go-git/go-git/v5/plumbing"
+	"github.com/go-git/go-git/v5/plumbing/object"
+	"github.com/go-git/go-git/v5/plumbing/storer"
+)
+
+// Commit describes a commit in the repository.
+type Commit struct {
+	// Hash is the SHA of the commit.
+	Hash string
+	// AuthorName is the name of the author of the commit.
+	AuthorName string
+	// AuthorEmail is the email of the author of the commit.
+	AuthorEmail string
+	// When is the time the commit was authored.
+	When time.Time
+	// Message is the full commit message.
+	Message string
+}
+
+func newCommit(c *object.Commit) Commit {
+	return Commit{
+		Hash:        c.Hash.String(),
+		AuthorName:  c.Author.Name,
+		AuthorEmail: c.Author.Email,
+		When:        c.Author.When,
+		Message:     c.Message,
+	}
+}
+
+// The methods below only read from the object database, and don't touch the worktree. As the
+// object database is modified by fetching, they lock the lock for reading, like SuspendRead().
+
+func (d *gitDirectory) ResolveRevision(rev string) (string, error) {
+	if err := d.lockRead("resolve-revision"); err != nil {
+		return "", err
+	}
+	defer d.lock.RUnlock()
+
+	commit, err := d.commitAt(rev)
+	if err != nil {
+		return "", err
+	}
+	return commit.Hash.String(), nil
+}
+
+func (d *gitDirectory) ListFilesAtRevision(rev string) ([]string, error) {
+	if err := d.lockRead("list-files"); err != nil {
+		return nil, err
+	}
+	defer d.lock.RUnlock()
+
+	tree, err := d.treeAt(rev)
+	if err != nil {
+		return nil, err
+	}
+
+	var files []string
+	err = tree.Files().ForEach(func(f *object.File) error {
+		files = append(files, f.Name)
+		return nil
+	})
+	return files, err
+}
+
+func (d *gitDirectory) ReadFileAtRevision(rev, path string) ([]byte, error) {
+	if err := d.lockRead("read-file"); err != nil {
+		return nil, err
+	}
+	defer d.lock.RUnlock()
+
+	tree, err := d.treeAt(rev)
+	if err != nil {
+		return nil, err
+	}
+
+	f, err := tree.File(path)
+	if err != nil {
+		return nil, fmt.Errorf("git get file %q at revision %q error: %w", path, rev, err)
+	}
+	r, err := f.Reader()
+	if err != nil {
+		return nil, err
+	}
+	defer r.Close()
+	return ioutil.ReadAll(r)
+}
+
+func (d *gitDirectory) History(path string) ([]Commit, error) {
+	if err := d.lockRead("history"); err != nil {
+		return nil, err
+	}
+	defer d.lock.RUnlock()
+
+	head, err := d.commitAt(d.Branch)
+	if err != nil {
+		return nil, err
+	}
+
+	iter, err := d.repo.Log(&git.LogOptions{
+		From:     head.Hash,
+		FileName: &path,
+	})
+	if err != nil {
+		return nil, fmt.Errorf("git log error: %v", err)
+	}
+	defer iter.Close()
+
+	var commits []Commit
+	err = iter.ForEach(func(c *object.Commit) error {
+		commits = append(commits, newCommit(c))
+		return nil
+	})
+	// The history of a shallow clone ends where the parent commits are missing
+	switch err {
+	case nil, io.EOF, storer.ErrStop, plumbing.ErrObjectNotFound:
+		return commits, nil
+	default:
+		return nil, fmt.Errorf("git log error: %v", err)
+	}
+}
+
+// lockRead locks the lock for reading, until Cleanup() is called
+func (d *gitDirectory) lockRead(operation string) error {
+	if err := d.lock.RLock(d.ctx, operation); err != nil {
+		return fmt.Errorf("cannot read the object database: %w", err)
+	}
+	return nil
+}
+
+// commitAt resolves the given revision into a commit
+func (d *gitDirectory) commitAt(rev string) (*object.Commit, error) {
+	// Make sure it's okay to read
+	if err := d.verifyRead(); err != nil {
+		return nil, err
+	}
+
+	hash, err := d.repo.ResolveRevision(plumbing.Revision(rev))
+	if err != nil {
+		return nil, fmt.Errorf("git resolve revision %q error: %w", rev, err)
+	}
+	commit, err := d.repo.CommitObject(*hash)
+	if err != nil {
+		return nil, fmt.Errorf("git get commit %q error: %w", hash, err)
+	}
+	return commit, nil
+}
+
+// treeAt returns the root tree of the commit the given revision resolves to
+func (d *gitDirectory) treeAt(rev string) (*object.Tree, error) {
+	commit, err := d.commitAt(rev)
+	if err != nil {
+		return nil, err
+	}
+	return commit.Tree()
+}
+
+// FileChange describes a changed file between two revisions. The paths are relative to the repository root.
+type FileChange struct {
+	// From is the path of the file before the change. It's empty if the file was created.
+	From string
+	// To is the path of the file after the change. It's empty if the file was deleted.
+	// If From and To differ, and both are set, the file was renamed.
+	To string
+}
+
+func (d *gitDirectory) Diff(from, to string) ([]FileChange, error) {
+	if err := d.lockRead("diff"); err != nil {
+		return nil, err
+	}
+	defer d.lock.RUnlock()
+	return d.diff(from, to)
+}
+
+// diff is like Diff, but expects the lock to be held
+func (d *gitDirectory) diff(from, to string) ([]FileChange, error) {
+	fromTree, err := d.treeAt(from)
+	if err != nil {
+		return nil, err
+	}
+	toTree, err := d.treeAt(to)
+	if err != nil {
+		return nil, err
+	}
+
+	changes, err := object.DiffTreeWithOptions(d.ctx, fromTree, toTree, object.DefaultDiffTreeOptions)
+	if err != nil {
+		return nil, fmt.Errorf("git diff error: %v", err)
+	}
+
+	fileChanges := make([]FileChange, 0, len(changes))
+	for _, change := range changes {
+		fileChanges = append(fileChanges, FileChange{
+			From: change.From.Name,
+			To:   change.To.Name,
+		})
+	}
+	return fileChanges, nil
+}
@@ -0,0 +1,74 @@
+package gitdir
+
+import (
+	"fmt"
+	"strings"
+
+	"k8s.io/apimachinery/pkg/util/version"
+)
+
+// semverConstraint is a single comparison of a semver range, e.g. ">=1.2.0"
+type semverConstraint struct {
+	op string
+	v  *version.Version
+}
+
+// semverRange is a set of constraints that all must match, e.g. ">=1.2.0 <2.0.0"
+type semverRange []semverConstraint
+
+// supported comparison operators, the two-character ones first so they are matched before their prefixes
+var semverOperators = []string{">=", "<=", "!=", ">", "<", "="}
+
+// parseSemverRange parses a range of space- or comma-separated constraints, consisting of an optional
+// comparison operator (default "=") and a semantic version, optionally prefixed with "v".
+func parseSemverRange(str string) (semverRange, error) {
+	fields := strings.FieldsFunc(str, func(r rune) bool {
+		return r == ' ' || r == ','
+	})
+	if len(fields) == 0 {
+		return nil, fmt.Errorf("empty semver range %q", str)
+	}
+
+	r := make(semverRange, 0, len(fields))
+	for _, field := range fields {
+		c := semverConstraint{op: "="}
+		for _, op := range semverOperators {
+			if strings.HasPrefix(field, op) {
+				c.op, field = op, strings.TrimPrefix(field, op)
+				break
+			}
+		}
+		v, err := version.ParseSemantic(field)
+		if err != nil {
+			return nil, fmt.Errorf("invalid semver range %q: %w", str, err)
+		}
+		c.v = v
+		r = append(r, c)
+	}
+	return r, nil
+}
+
+// matches reports whether v satisfies all constraints of the range
+func (r semverRange) matches(v *version.Version) bool {
+	for _, c := range r {
+		var ok bool
+		switch c.op {
+		case "=":
+			ok = !v.LessThan(c.v) && !c.v.LessThan(v)
+		case "!=":
+			ok = v.LessThan(c.v) || c.v.LessThan(v)
+		case ">":
+			ok = c.v.LessThan(v)
+		case ">=":
+			ok = v.AtLeast(c.v)
+		case "<":
+			ok = v.LessThan(c.v)
+		case "<=":
+			ok = !c.v.LessThan(v)
+		}
+		if !ok {
+			return false
+		}
+	}
+	return true
+}
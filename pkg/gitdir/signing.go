@@ -0,0 +1,71 @@
+package gitdir
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+
+	"github.com/go-git/go-git/v5/plumbing"
+	log "github.com/sirupsen/logrus"
+	"golang.org/x/crypto/openpgp"
+)
+
+// ErrUntrustedCommit happens if GitDirectoryOptions.TrustedKeyRing is set, and a new commit on the main
+// branch isn't signed by any of the trusted keys.
+var ErrUntrustedCommit = errors.New("the commit isn't signed by a trusted key")
+
+// NewSignKey reads an armored OpenPGP private key, e.g. exported using "gpg --export-secret-keys --armor",
+// to be used for GitDirectoryOptions.SignKey. If the private key is encrypted, it's decrypted using the
+// given passphrase.
+func NewSignKey(armoredKey []byte, passphrase string) (*openpgp.Entity, error) {
+	entities, err := openpgp.ReadArmoredKeyRing(bytes.NewReader(armoredKey))
+	if err != nil {
+		return nil, err
+	}
+	if len(entities) != 1 {
+		return nil, fmt.Errorf("expected exactly one key, got %d", len(entities))
+	}
+
+	entity := entities[0]
+	if entity.PrivateKey == nil {
+		return nil, errors.New("the key doesn't contain a private key")
+	}
+	if entity.PrivateKey.Encrypted {
+		if len(passphrase) == 0 {
+			return nil, errors.New("the private key is encrypted, but no passphrase was given")
+		}
+		if err := entity.PrivateKey.Decrypt([]byte(passphrase)); err != nil {
+			return nil, fmt.Errorf("failed to decrypt private key: %v", err)
+		}
+	}
+	return entity, nil
+}
+
+func (d *gitDirectory) verifySignatures() bool {
+	return len(d.TrustedKeyRing) != 0
+}
+
+// verifyCommit makes sure the given commit is signed by a key in the trusted keyring, if set.
+// Only the commit itself is verified, as its signature covers the whole tree of the commit.
+func (d *gitDirectory) verifyCommit(hash plumbing.Hash) error {
+	if !d.verifySignatures() {
+		return nil
+	}
+
+	commit, err := d.repo.CommitObject(hash)
+	if err != nil {
+		return fmt.Errorf("git get commit %q error: %v", hash, err)
+	}
+	if len(commit.PGPSignature) == 0 {
+		return fmt.Errorf("%w: commit %s isn't signed", ErrUntrustedCommit, hash)
+	}
+	entity, err := commit.Verify(d.TrustedKeyRing)
+	if err != nil {
+		return fmt.Errorf("%w: commit %s: %v", ErrUntrustedCommit, hash, err)
+	}
+
+	for _, identity := range entity.Identities {
+		log.Debugf("Commit %s has a valid signature by %q", hash, identity.Name)
+	}
+	return nil
+}
@@ -0,0 +1,169 @@
+package gitdir_test
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	git "github.com/go-git/go-git/v5"
+	"github.com/go-git/go-git/v5/plumbing"
+	"github.com/weaveworks/libgitops/pkg/gitdir"
+	"github.com/weaveworks/libgitops/pkg/storage/transaction/pullrequest/local"
+	"golang.org/x/crypto/openpgp"
+	"golang.org/x/crypto/openpgp/armor"
+)
+
+// armoredKey serializes the public, or private, key of the entity
+func armoredKey(t *testing.T, entity *openpgp.Entity, private bool) string {
+	t.Helper()
+	var buf bytes.Buffer
+	blockType := openpgp.PublicKeyType
+	if private {
+		blockType = openpgp.PrivateKeyType
+	}
+	w, err := armor.Encode(&buf, blockType, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if private {
+		err = entity.SerializePrivate(w, nil)
+	} else {
+		err = entity.Serialize(w)
+	}
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := w.Close(); err != nil {
+		t.Fatal(err)
+	}
+	return buf.String()
+}
+
+func TestSigning(t *testing.T) {
+	trusted, err := openpgp.NewEntity("Trusted", "", "trusted@example.com", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	untrusted, err := openpgp.NewEntity("Untrusted", "", "untrusted@example.com", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	trustedKeyRing := armoredKey(t, trusted, false)
+	signKey, err := gitdir.NewSignKey([]byte(armoredKey(t, trusted, true)), "")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tmpDir, err := ioutil.TempDir("", "libgitops")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(tmpDir)
+	repoDir := filepath.Join(tmpDir, "repo.git")
+	if err := local.InitBareRepository(repoDir, "master", map[string][]byte{"a.yaml": []byte("a: 0")}); err != nil {
+		t.Fatal(err)
+	}
+	newGitDirectory := func(opts gitdir.GitDirectoryOptions) (gitdir.GitDirectory, error) {
+		opts.URL, opts.Interval = repoDir, time.Hour
+		d, err := gitdir.NewGitDirectory(nil, opts)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return d, d.StartCheckoutLoop()
+	}
+	ctx := context.Background()
+	// commitFile commits a new content of a.yaml through a new clone with the given sign key, and returns the commit
+	commitFile := func(signKey *openpgp.Entity, content string) plumbing.Hash {
+		t.Helper()
+		writer, err := newGitDirectory(gitdir.GitDirectoryOptions{SignKey: signKey})
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer writer.Cleanup()
+		if err := ioutil.WriteFile(filepath.Join(writer.Dir(), "a.yaml"), []byte(content), 0644); err != nil {
+			t.Fatal(err)
+		}
+		if err := writer.Commit(ctx, gitdir.CommitSpec{
+			Author:  gitdir.Signature{Name: "Test", Email: "test@example.com"},
+			Message: "Update a",
+		}); err != nil {
+			t.Fatal(err)
+		}
+		head, err := writer.ResolveRevision("HEAD")
+		if err != nil {
+			t.Fatal(err)
+		}
+		return plumbing.NewHash(head)
+	}
+
+	// An untrusted clone isn't checked out, and starting again verifies the commit again
+	untrustedClone, err := gitdir.NewGitDirectory(nil, gitdir.GitDirectoryOptions{
+		URL: repoDir, Interval: time.Hour, TrustedKeyRing: trustedKeyRing,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer untrustedClone.Cleanup()
+	for i := 0; i < 2; i++ {
+		if err := untrustedClone.StartCheckoutLoop(); !errors.Is(err, gitdir.ErrUntrustedCommit) {
+			t.Errorf("StartCheckoutLoop() #%d of an unsigned commit error = %v, want ErrUntrustedCommit", i+1, err)
+		}
+		if _, err := os.Stat(filepath.Join(untrustedClone.Dir(), "a.yaml")); !os.IsNotExist(err) {
+			t.Errorf("a.yaml of an unsigned commit was checked out after StartCheckoutLoop() #%d", i+1)
+		}
+	}
+	if err := untrustedClone.Pull(ctx); !errors.Is(err, gitdir.ErrNotStarted) {
+		t.Errorf("Pull() of an untrusted clone error = %v, want ErrNotStarted", err)
+	}
+
+	// Commits are signed with the SignKey
+	signed := commitFile(signKey, "a: 1")
+	repo, err := git.PlainOpen(repoDir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	commit, err := repo.CommitObject(signed)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(commit.PGPSignature) == 0 {
+		t.Fatal("the commit isn't signed")
+	}
+	if _, err := commit.Verify(trustedKeyRing); err != nil {
+		t.Errorf("the commit signature is invalid: %v", err)
+	}
+
+	// Signed commits are accepted when verifying signatures
+	d, err := newGitDirectory(gitdir.GitDirectoryOptions{TrustedKeyRing: trustedKeyRing})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer d.Cleanup()
+	last := commitFile(signKey, "a: 2")
+	if err := d.Pull(ctx); err != nil {
+		t.Errorf("Pull() of a signed commit error = %v", err)
+	}
+
+	// Unsigned, and untrusted commits are rejected, without moving the checkout
+	untrustedKey, err := gitdir.NewSignKey([]byte(armoredKey(t, untrusted, true)), "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	for name, key := range map[string]*openpgp.Entity{"unsigned": nil, "untrusted": untrustedKey} {
+		commitFile(key, "a: "+name)
+		if err := d.Pull(ctx); !errors.Is(err, gitdir.ErrUntrustedCommit) {
+			t.Errorf("Pull() of %s commit error = %v, want ErrUntrustedCommit", name, err)
+		}
+		if head, err := d.ResolveRevision("HEAD"); err != nil || head != last.String() {
+			t.Errorf("HEAD = %s, %v after pulling %s commit, want %s", head, err, name, last)
+		}
+		if content, err := ioutil.ReadFile(filepath.Join(d.Dir(), "a.yaml")); err != nil || string(content) != "a: 2" {
+			t.Errorf("a.yaml = %q, %v after pulling %s commit, want %q", content, err, name, "a: 2")
+		}
+	}
+}
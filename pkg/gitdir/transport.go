@@ -8,6 +8,7 @@ import (
 	"github.com/go-git/go-git/v5/plumbing/transport"
 	"github.com/go-git/go-git/v5/plumbing/transport/http"
 	"github.com/go-git/go-git/v5/plumbing/transport/ssh"
+	gossh "golang.org/x/crypto/ssh"
 )
 
 // AuthMethod specifies the authentication method and related credentials for connecting
@@ -30,18 +31,60 @@ type AuthMethod interface {
 // If you want to use the default git CLI behavior, populate this byte slice with contents from
 // ioutil.ReadFile("~/.ssh/known_hosts").
 func NewSSHAuthMethod(identityFile, knownHostsFile []byte) (AuthMethod, error) {
+	return NewSSHAuthMethodWithPassphrase(identityFile, "", knownHostsFile)
+}
+
+// NewSSHAuthMethodWithPassphrase is like NewSSHAuthMethod, but for an identity encrypted with
+// the given passphrase.
+func NewSSHAuthMethodWithPassphrase(identityFile []byte, passphrase string, knownHostsFile []byte) (AuthMethod, error) {
 	if len(identityFile) == 0 || len(knownHostsFile) == 0 {
 		return nil, errors.New("invalid identityFile, knownHostsFile options")
 	}
 
-	pk, err := ssh.NewPublicKeys("git", identityFile, "")
+	callback, err := knownhosts.New(knownHostsFile)
 	if err != nil {
 		return nil, err
 	}
+	return newPublicKeysAuthMethod(identityFile, passphrase, callback)
+}
+
+// NewSSHAgentAuthMethod creates a new AuthMethod for the Git SSH protocol, using the identities
+// of the SSH agent listening on SSH_AUTH_SOCK. See NewSSHAuthMethod for the knownHostsFile.
+func NewSSHAgentAuthMethod(knownHostsFile []byte) (AuthMethod, error) {
+	if len(knownHostsFile) == 0 {
+		return nil, errors.New("invalid knownHostsFile option")
+	}
+
 	callback, err := knownhosts.New(knownHostsFile)
 	if err != nil {
 		return nil, err
 	}
+	agent, err := ssh.NewSSHAgentAuth("git")
+	if err != nil {
+		return nil, err
+	}
+	agent.HostKeyCallback = callback
+
+	return &authMethod{
+		AuthMethod: agent,
+		t:          gitprovider.TransportTypeGit,
+	}, nil
+}
+
+// NewInsecureSSHAuthMethod is like NewSSHAuthMethodWithPassphrase, but accepts any host key of the
+// remote. This makes the connection vulnerable to man-in-the-middle attacks, only use it for testing.
+func NewInsecureSSHAuthMethod(identityFile []byte, passphrase string) (AuthMethod, error) {
+	if len(identityFile) == 0 {
+		return nil, errors.New("invalid identityFile option")
+	}
+	return newPublicKeysAuthMethod(identityFile, passphrase, gossh.InsecureIgnoreHostKey())
+}
+
+func newPublicKeysAuthMethod(identityFile []byte, passphrase string, callback gossh.HostKeyCallback) (AuthMethod, error) {
+	pk, err := ssh.NewPublicKeys("git", identityFile, passphrase)
+	if err != nil {
+		return nil, err
+	}
 	pk.HostKeyCallback = callback
 
 	return &authMethod{
@@ -63,6 +106,28 @@ func NewHTTPSAuthMethod(username, password string) (AuthMethod, error) {
 	}, nil
 }
 
+// NewHTTPSTokenAuthMethod creates a new AuthMethod for the Git HTTPS protocol, sending the given
+// token in an "Authorization: Bearer" header. Note that e.g. GitHub and GitLab don't accept
+// bearer tokens for Git operations, use NewHTTPSOAuthMethod for their access tokens instead.
+func NewHTTPSTokenAuthMethod(token string) (AuthMethod, error) {
+	if len(token) == 0 {
+		return nil, errors.New("invalid token option")
+	}
+	return &authMethod{
+		AuthMethod: &http.TokenAuth{
+			Token: token,
+		},
+		t: gitprovider.TransportTypeHTTPS,
+	}, nil
+}
+
+// NewHTTPSOAuthMethod creates a new AuthMethod for the Git HTTPS protocol, using an OAuth or personal
+// access token, e.g. a GitHub App installation token or a GitLab access token, as the basic auth password.
+func NewHTTPSOAuthMethod(token string) (AuthMethod, error) {
+	// GitLab requires the "oauth2" username for OAuth tokens, GitHub accepts any username
+	return NewHTTPSAuthMethod("oauth2", token)
+}
+
 type authMethod struct {
 	transport.AuthMethod
 	t gitprovider.TransportType
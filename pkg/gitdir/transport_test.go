@@ -0,0 +1,140 @@
+package gitdir
+
+import (
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/pem"
+	"testing"
+
+	"github.com/fluxcd/go-git-providers/gitprovider"
+	gossh "golang.org/x/crypto/ssh"
+)
+
+func TestAuthMethods(t *testing.T) {
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+	block := &pem.Block{Type: "RSA PRIVATE KEY", Bytes: x509.MarshalPKCS1PrivateKey(key)}
+	identity := pem.EncodeToMemory(block)
+	// Legacy PEM encryption, which is still supported by ssh.ParsePrivateKeyWithPassphrase
+	encryptedBlock, err := x509.EncryptPEMBlock(rand.Reader, block.Type, block.Bytes, []byte("secret"), x509.PEMCipherAES256)
+	if err != nil {
+		t.Fatal(err)
+	}
+	encryptedIdentity := pem.EncodeToMemory(encryptedBlock)
+	publicKey, err := gossh.NewPublicKey(&key.PublicKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	knownHosts := append([]byte("github.com "), gossh.MarshalAuthorizedKey(publicKey)...)
+
+	tests := []struct {
+		name    string
+		newAuth func() (AuthMethod, error)
+		want    gitprovider.TransportType
+		wantErr bool
+	}{
+		{
+			name:    "ssh",
+			newAuth: func() (AuthMethod, error) { return NewSSHAuthMethod(identity, knownHosts) },
+			want:    gitprovider.TransportTypeGit,
+		},
+		{
+			name:    "ssh without identity",
+			newAuth: func() (AuthMethod, error) { return NewSSHAuthMethod(nil, knownHosts) },
+			wantErr: true,
+		},
+		{
+			name:    "ssh without known_hosts",
+			newAuth: func() (AuthMethod, error) { return NewSSHAuthMethod(identity, nil) },
+			wantErr: true,
+		},
+		{
+			name:    "ssh with invalid identity",
+			newAuth: func() (AuthMethod, error) { return NewSSHAuthMethod([]byte("not a key"), knownHosts) },
+			wantErr: true,
+		},
+		{
+			name:    "ssh with invalid known_hosts",
+			newAuth: func() (AuthMethod, error) { return NewSSHAuthMethod(identity, []byte("github.com ssh-rsa not-a-key")) },
+			wantErr: true,
+		},
+		{
+			name: "ssh with passphrase",
+			newAuth: func() (AuthMethod, error) {
+				return NewSSHAuthMethodWithPassphrase(encryptedIdentity, "secret", knownHosts)
+			},
+			want: gitprovider.TransportTypeGit,
+		},
+		{
+			name: "ssh with wrong passphrase",
+			newAuth: func() (AuthMethod, error) {
+				return NewSSHAuthMethodWithPassphrase(encryptedIdentity, "wrong", knownHosts)
+			},
+			wantErr: true,
+		},
+		{
+			name:    "ssh agent without known_hosts",
+			newAuth: func() (AuthMethod, error) { return NewSSHAgentAuthMethod(nil) },
+			wantErr: true,
+		},
+		{
+			name:    "insecure ssh",
+			newAuth: func() (AuthMethod, error) { return NewInsecureSSHAuthMethod(encryptedIdentity, "secret") },
+			want:    gitprovider.TransportTypeGit,
+		},
+		{
+			name:    "insecure ssh without identity",
+			newAuth: func() (AuthMethod, error) { return NewInsecureSSHAuthMethod(nil, "") },
+			wantErr: true,
+		},
+		{
+			name:    "https",
+			newAuth: func() (AuthMethod, error) { return NewHTTPSAuthMethod("user", "password") },
+			want:    gitprovider.TransportTypeHTTPS,
+		},
+		{
+			name:    "https without username",
+			newAuth: func() (AuthMethod, error) { return NewHTTPSAuthMethod("", "password") },
+			wantErr: true,
+		},
+		{
+			name:    "https without password",
+			newAuth: func() (AuthMethod, error) { return NewHTTPSAuthMethod("user", "") },
+			wantErr: true,
+		},
+		{
+			name:    "https token",
+			newAuth: func() (AuthMethod, error) { return NewHTTPSTokenAuthMethod("token") },
+			want:    gitprovider.TransportTypeHTTPS,
+		},
+		{
+			name:    "https without token",
+			newAuth: func() (AuthMethod, error) { return NewHTTPSTokenAuthMethod("") },
+			wantErr: true,
+		},
+		{
+			name:    "https oauth",
+			newAuth: func() (AuthMethod, error) { return NewHTTPSOAuthMethod("token") },
+			want:    gitprovider.TransportTypeHTTPS,
+		},
+		{
+			name:    "https oauth without token",
+			newAuth: func() (AuthMethod, error) { return NewHTTPSOAuthMethod("") },
+			wantErr: true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			auth, err := tt.newAuth()
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("error = %v, wantErr %t", err, tt.wantErr)
+			}
+			if err == nil && auth.TransportType() != tt.want {
+				t.Errorf("TransportType() = %q, want %q", auth.TransportType(), tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,185 @@
+package gitdir
+
+import (
+	"crypto/hmac"
+	"crypto/sha1"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"hash"
+	"io/ioutil"
+	"net/http"
+	"strings"
+
+	"github.com/go-git/go-git/v5/plumbing"
+	log "github.com/sirupsen/logrus"
+)
+
+const (
+	// maxWebhookPayloadSize limits how much of the request body is read, 25MB is what GitHub caps payloads at
+	maxWebhookPayloadSize = 25 * 1024 * 1024
+
+	githubEventHeader        = "X-GitHub-Event"
+	githubSignatureHeader    = "X-Hub-Signature"
+	githubSignature256Header = "X-Hub-Signature-256"
+	gitlabEventHeader        = "X-Gitlab-Event"
+	gitlabTokenHeader        = "X-Gitlab-Token"
+
+	githubPushEvent = "push"
+	gitlabPushEvent = "Push Hook"
+	// GitLab sends a separate event for pushed tags
+	gitlabTagPushEvent = "Tag Push Hook"
+)
+
+// ErrInvalidSignature is returned if the webhook payload couldn't be verified using the shared secret.
+var ErrInvalidSignature = errors.New("the webhook signature is invalid")
+
+// NewWebhookHandler returns a http.Handler that accepts GitHub and GitLab push webhooks, and
+// requests an immediate sync of the GitDirectory, see RequestSync(), if the push was to its
+// repository and main branch. Bursts of pushes are coalesced into a single pull. The payloads
+// are verified using the secret configured for the webhook: GitHub signs the payload using HMAC,
+// GitLab sends the secret as-is in a header. The periodic checkout loop still runs as a fallback,
+// in case webhooks are lost.
+func NewWebhookHandler(d GitDirectory, secret string) (http.Handler, error) {
+	if len(secret) == 0 {
+		return nil, errors.New("invalid secret option")
+	}
+	// The repository ref is needed to match the push events against
+	if d.RepositoryRef() == nil {
+		return nil, errors.New("the GitDirectory has no repository ref")
+	}
+	return &webhookHandler{d: d, secret: []byte(secret)}, nil
+}
+
+type webhookHandler struct {
+	d      GitDirectory
+	secret []byte
+}
+
+// pushEvent contains the fields of GitHub and GitLab push event payloads that are
+// used to check whether a sync is needed.
+type pushEvent struct {
+	// Ref is the full name of the pushed ref, e.g. "refs/heads/master"
+	Ref string `json:"ref"`
+	// Repository is set by GitHub
+	Repository struct {
+		FullName string `json:"full_name"`
+	} `json:"repository"`
+	// Project is set by GitLab
+	Project struct {
+		PathWithNamespace string `json:"path_with_namespace"`
+	} `json:"project"`
+}
+
+func (h *webhookHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "only POST is supported", http.StatusMethodNotAllowed)
+		return
+	}
+
+	payload, err := ioutil.ReadAll(http.MaxBytesReader(w, r.Body, maxWebhookPayloadSize))
+	if err != nil {
+		http.Error(w, fmt.Sprintf("failed to read payload: %v", err), http.StatusBadRequest)
+		return
+	}
+
+	// Verify the payload, and figure out if it's a push event
+	var isPush bool
+	switch {
+	case r.Header.Get(githubEventHeader) != "":
+		err = h.verifyGitHub(r.Header, payload)
+		isPush = r.Header.Get(githubEventHeader) == githubPushEvent
+	case r.Header.Get(gitlabEventHeader) != "":
+		err = h.verifyGitLab(r.Header)
+		event := r.Header.Get(gitlabEventHeader)
+		isPush = event == gitlabPushEvent || event == gitlabTagPushEvent
+	default:
+		http.Error(w, "unknown webhook type", http.StatusBadRequest)
+		return
+	}
+	if err != nil {
+		log.Debugf("webhook: Payload verification failed: %v", err)
+		http.Error(w, err.Error(), http.StatusUnauthorized)
+		return
+	}
+
+	// Respond to e.g. GitHub's ping event, but don't do anything
+	if !isPush {
+		log.Tracef("webhook: Ignoring non-push event")
+		w.WriteHeader(http.StatusOK)
+		return
+	}
+
+	event := pushEvent{}
+	if err := json.Unmarshal(payload, &event); err != nil {
+		http.Error(w, fmt.Sprintf("failed to decode payload: %v", err), http.StatusBadRequest)
+		return
+	}
+
+	// Only sync if the push was to the right repository and branch
+	if !h.matches(&event) {
+		log.Tracef("webhook: Ignoring push to %q in %q", event.Ref, event.fullName())
+		w.WriteHeader(http.StatusOK)
+		return
+	}
+
+	// Let the checkout loop sync in the background, so the webhook sender doesn't time out
+	log.Debugf("webhook: Got push to %q in %q, requesting sync", event.Ref, event.fullName())
+	h.d.RequestSync()
+	w.WriteHeader(http.StatusAccepted)
+}
+
+func (h *webhookHandler) matches(event *pushEvent) bool {
+	repoRef := h.d.RepositoryRef()
+	fullName := fmt.Sprintf("%s/%s", repoRef.GetIdentity(), repoRef.GetRepository())
+	// Pushed tags are synced too, in case a tag range is followed
+	return strings.EqualFold(event.fullName(), fullName) &&
+		(event.Ref == plumbing.NewBranchReferenceName(h.d.MainBranch()).String() ||
+			strings.HasPrefix(event.Ref, "refs/tags/"))
+}
+
+func (e *pushEvent) fullName() string {
+	if len(e.Repository.FullName) != 0 {
+		return e.Repository.FullName
+	}
+	return e.Project.PathWithNamespace
+}
+
+// verifyGitHub verifies the HMAC signature of the payload, preferring SHA-256 over SHA-1
+func (h *webhookHandler) verifyGitHub(header http.Header, payload []byte) error {
+	if sig := header.Get(githubSignature256Header); len(sig) != 0 {
+		return verifyHMAC(sha256.New, "sha256=", sig, h.secret, payload)
+	}
+	if sig := header.Get(githubSignatureHeader); len(sig) != 0 {
+		return verifyHMAC(sha1.New, "sha1=", sig, h.secret, payload)
+	}
+	return fmt.Errorf("%w: no signature given", ErrInvalidSignature)
+}
+
+// verifyGitLab compares the token sent by GitLab to the secret
+func (h *webhookHandler) verifyGitLab(header http.Header) error {
+	if subtle.ConstantTimeCompare([]byte(header.Get(gitlabTokenHeader)), h.secret) != 1 {
+		return fmt.Errorf("%w: token mismatch", ErrInvalidSignature)
+	}
+	return nil
+}
+
+func verifyHMAC(hashFn func() hash.Hash, prefix, signature string, secret, payload []byte) error {
+	if !strings.HasPrefix(signature, prefix) {
+		return fmt.Errorf("%w: expected prefix %q", ErrInvalidSignature, prefix)
+	}
+	given, err := hex.DecodeString(strings.TrimPrefix(signature, prefix))
+	if err != nil {
+		return fmt.Errorf("%w: %v", ErrInvalidSignature, err)
+	}
+
+	mac := hmac.New(hashFn, secret)
+	_, _ = mac.Write(payload)
+	if !hmac.Equal(given, mac.Sum(nil)) {
+		return fmt.Errorf("%w: digest mismatch", ErrInvalidSignature)
+	}
+	return nil
+}
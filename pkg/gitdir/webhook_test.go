@@ -0,0 +1,147 @@
+package gitdir
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/fluxcd/go-git-providers/gitprovider"
+)
+
+const testSecret = "s3cr3t"
+
+type fakeGitDirectory struct {
+	GitDirectory
+	syncRequests int
+}
+
+func (d *fakeGitDirectory) MainBranch() string { return "master" }
+func (d *fakeGitDirectory) RepositoryRef() gitprovider.RepositoryRef {
+	return &gitprovider.OrgRepositoryRef{
+		OrganizationRef: gitprovider.OrganizationRef{Domain: "github.com", Organization: "weaveworks"},
+		RepositoryName:  "libgitops",
+	}
+}
+func (d *fakeGitDirectory) RequestSync() {
+	d.syncRequests++
+}
+
+func githubSignature(payload string) string {
+	mac := hmac.New(sha256.New, []byte(testSecret))
+	_, _ = mac.Write([]byte(payload))
+	return "sha256=" + hex.EncodeToString(mac.Sum(nil))
+}
+
+func TestWebhookHandler(t *testing.T) {
+	githubPush := `{"ref": "refs/heads/master", "repository": {"full_name": "weaveworks/libgitops"}}`
+	gitlabPush := `{"ref": "refs/heads/master", "project": {"path_with_namespace": "weaveworks/libgitops"}}`
+	otherBranch := `{"ref": "refs/heads/feature", "repository": {"full_name": "weaveworks/libgitops"}}`
+	otherRepo := `{"ref": "refs/heads/master", "repository": {"full_name": "weaveworks/ignite"}}`
+	gitlabTagPush := `{"ref": "refs/tags/v1.0.0", "project": {"path_with_namespace": "weaveworks/libgitops"}}`
+
+	tests := []struct {
+		name       string
+		payload    string
+		header     map[string]string
+		wantStatus int
+		wantSync   bool
+	}{
+		{
+			name:       "github push",
+			payload:    githubPush,
+			header:     map[string]string{githubEventHeader: "push", githubSignature256Header: githubSignature(githubPush)},
+			wantStatus: http.StatusAccepted,
+			wantSync:   true,
+		},
+		{
+			name:       "github bad signature",
+			payload:    githubPush,
+			header:     map[string]string{githubEventHeader: "push", githubSignature256Header: githubSignature(otherRepo)},
+			wantStatus: http.StatusUnauthorized,
+		},
+		{
+			name:       "github ping",
+			payload:    `{}`,
+			header:     map[string]string{githubEventHeader: "ping", githubSignature256Header: githubSignature(`{}`)},
+			wantStatus: http.StatusOK,
+		},
+		{
+			name:       "github push to other branch",
+			payload:    otherBranch,
+			header:     map[string]string{githubEventHeader: "push", githubSignature256Header: githubSignature(otherBranch)},
+			wantStatus: http.StatusOK,
+		},
+		{
+			name:       "github push to other repo",
+			payload:    otherRepo,
+			header:     map[string]string{githubEventHeader: "push", githubSignature256Header: githubSignature(otherRepo)},
+			wantStatus: http.StatusOK,
+		},
+		{
+			name:       "gitlab push",
+			payload:    gitlabPush,
+			header:     map[string]string{gitlabEventHeader: "Push Hook", gitlabTokenHeader: testSecret},
+			wantStatus: http.StatusAccepted,
+			wantSync:   true,
+		},
+		{
+			name:       "gitlab tag push",
+			payload:    gitlabTagPush,
+			header:     map[string]string{gitlabEventHeader: "Tag Push Hook", gitlabTokenHeader: testSecret},
+			wantStatus: http.StatusAccepted,
+			wantSync:   true,
+		},
+		{
+			name:       "gitlab bad token",
+			payload:    gitlabPush,
+			header:     map[string]string{gitlabEventHeader: "Push Hook", gitlabTokenHeader: "foo"},
+			wantStatus: http.StatusUnauthorized,
+		},
+		{
+			name:       "unknown sender",
+			payload:    githubPush,
+			wantStatus: http.StatusBadRequest,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			d := &fakeGitDirectory{}
+			h, err := NewWebhookHandler(d, testSecret)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			req := httptest.NewRequest(http.MethodPost, "/", strings.NewReader(tt.payload))
+			for k, v := range tt.header {
+				req.Header.Set(k, v)
+			}
+			rec := httptest.NewRecorder()
+			h.ServeHTTP(rec, req)
+
+			if rec.Code != tt.wantStatus {
+				t.Errorf("got status %d, want %d", rec.Code, tt.wantStatus)
+			}
+			wantRequests := 0
+			if tt.wantSync {
+				wantRequests = 1
+			}
+			if d.syncRequests != wantRequests {
+				t.Errorf("got %d sync requests, want %d", d.syncRequests, wantRequests)
+			}
+		})
+	}
+}
+
+func TestRequestSyncCoalesces(t *testing.T) {
+	d := &gitDirectory{syncPending: make(chan struct{}, 1)}
+	for i := 0; i < 3; i++ {
+		d.RequestSync()
+	}
+	if n := len(d.syncPending); n != 1 {
+		t.Errorf("got %d pending syncs, want 1", n)
+	}
+}
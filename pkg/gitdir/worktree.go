@@ -0,0 +1,188 @@
+package gitdir
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"os"
+
+	"github.com/go-git/go-billy/v5/osfs"
+	git "github.com/go-git/go-git/v5"
+	"github.com/go-git/go-git/v5/plumbing"
+	"github.com/go-git/go-git/v5/plumbing/format/index"
+	"github.com/go-git/go-git/v5/storage"
+	log "github.com/sirupsen/logrus"
+)
+
+// Worktree is an isolated checkout of a branch in a temporary directory. It shares the object database and
+// remote of the GitDirectory, but has its own index and HEAD. Hence, multiple branches can be modified and
+// committed concurrently, without affecting the main branch checked out in GitDirectory.Dir().
+type Worktree interface {
+	// Dir returns the temporary directory the branch is checked out in.
+	Dir() string
+	// Branch returns the checked out branch.
+	Branch() string
+	// Commit creates a commit of all changes in the worktree as described by spec, and pushes the branch.
+	// It waits for any pending operations of the GitDirectory, like Suspend(). If the branch was moved since
+	// it was checked out, e.g. by another Worktree of the same branch, ErrBranchChanged is returned.
+	Commit(ctx context.Context, spec CommitSpec) error
+	// Cleanup removes the temporary directory. The Worktree can't be used afterwards.
+	Cleanup() error
+}
+
+func (d *gitDirectory) OpenWorktree(ctx context.Context, branchName string, reset bool) (Worktree, bool, error) {
+	// Make sure it's okay to write
+	if err := d.verifyWrite(); err != nil {
+		return nil, false, err
+	}
+	// Lock the lock while the repository is modified, and unlock it when exiting
+	if err := d.lock.Lock(ctx, "open-worktree"); err != nil {
+		return nil, false, fmt.Errorf("cannot open worktree: %w", err)
+	}
+	defer d.lock.Unlock()
+
+	exists, branchRef, err := d.prepareBranch(ctx, branchName, reset)
+	if err != nil {
+		return nil, exists, err
+	}
+
+	dir, err := ioutil.TempDir("", "libgitops-worktree")
+	if err != nil {
+		return nil, exists, err
+	}
+	w := &worktree{d: d, dir: dir, branch: branchName}
+	if err := w.checkout(branchRef); err != nil {
+		_ = w.Cleanup()
+		return nil, exists, err
+	}
+	log.Debugf("Checked out branch %q in worktree %q", branchName, dir)
+	return w, exists, nil
+}
+
+type worktree struct {
+	d      *gitDirectory
+	dir    string
+	branch string
+
+	// go-git objects, using the repository storage of d with a separate index and HEAD
+	repo *git.Repository
+	wt   *git.Worktree
+}
+
+func (w *worktree) checkout(branchRef plumbing.ReferenceName) (err error) {
+	w.repo, err = git.Open(NewWorktreeStorer(w.d.repo.Storer), osfs.New(w.dir))
+	if err != nil {
+		return fmt.Errorf("git open error: %v", err)
+	}
+	w.wt, err = w.repo.Worktree()
+	if err != nil {
+		return fmt.Errorf("git get worktree error: %v", err)
+	}
+	if err := w.wt.Checkout(&git.CheckoutOptions{Branch: branchRef, Force: true}); err != nil {
+		return fmt.Errorf("git checkout error: %v", err)
+	}
+	return nil
+}
+
+func (w *worktree) Dir() string {
+	return w.dir
+}
+
+func (w *worktree) Branch() string {
+	return w.branch
+}
+
+func (w *worktree) Commit(ctx context.Context, spec CommitSpec) error {
+	// Lock the lock now that we're starting, and unlock it when exiting
+	if err := w.d.lock.Lock(ctx, "worktree-commit"); err != nil {
+		return fmt.Errorf("cannot commit: %w", err)
+	}
+	defer w.d.lock.Unlock()
+
+	// New commits on the main branch are observed by the checkout loop when pulling
+	_, err := w.d.commit(ctx, w.repo, w.wt, spec)
+	return err
+}
+
+func (w *worktree) Cleanup() error {
+	if err := os.RemoveAll(w.dir); err != nil {
+		log.Errorf("Failed to clean up git worktree: %v", err)
+		return err
+	}
+	return nil
+}
+
+// NewWorktreeStorer wraps the storage of a repository, keeping the index and HEAD in memory. Opening
+// a worktree with it hence doesn't modify the index and checked out branch of the repository, or add
+// an index to a bare repository, while commits and branch updates are still written to it. Commits
+// only move the checked out branch if it still points to the commit it was checked out at, otherwise
+// ErrBranchChanged is returned, so that concurrent worktrees of a branch don't overwrite each other.
+func NewWorktreeStorer(s storage.Storer) storage.Storer {
+	return &worktreeStorer{Storer: s}
+}
+
+// worktreeStorer is the storage.Storer returned by NewWorktreeStorer
+type worktreeStorer struct {
+	storage.Storer
+	index *index.Index
+	head  *plumbing.Reference
+	// base is the checked out branch, as it was when checked out or last committed to
+	base *plumbing.Reference
+}
+
+func (s *worktreeStorer) SetIndex(idx *index.Index) error {
+	s.index = idx
+	return nil
+}
+
+func (s *worktreeStorer) Index() (*index.Index, error) {
+	if s.index == nil {
+		return &index.Index{Version: 2}, nil
+	}
+	return s.index, nil
+}
+
+func (s *worktreeStorer) SetReference(ref *plumbing.Reference) error {
+	if ref.Name() == plumbing.HEAD {
+		return s.setHead(ref)
+	}
+	if s.base != nil && ref.Name() == s.base.Name() {
+		if err := s.Storer.CheckAndSetReference(ref, s.base); err != nil {
+			return fmt.Errorf("%w: %q: %v", ErrBranchChanged, ref.Name().Short(), err)
+		}
+		s.base = ref
+		return nil
+	}
+	return s.Storer.SetReference(ref)
+}
+
+func (s *worktreeStorer) CheckAndSetReference(new, old *plumbing.Reference) error {
+	if new.Name() == plumbing.HEAD {
+		return s.setHead(new)
+	}
+	return s.Storer.CheckAndSetReference(new, old)
+}
+
+func (s *worktreeStorer) Reference(name plumbing.ReferenceName) (*plumbing.Reference, error) {
+	if name == plumbing.HEAD && s.head != nil {
+		return s.head, nil
+	}
+	return s.Storer.Reference(name)
+}
+
+// setHead sets the in-memory HEAD, and records the commit of the checked out branch, if it exists yet
+func (s *worktreeStorer) setHead(ref *plumbing.Reference) error {
+	s.head, s.base = ref, nil
+	if ref.Type() != plumbing.SymbolicReference {
+		return nil
+	}
+	base, err := s.Storer.Reference(ref.Target())
+	if errors.Is(err, plumbing.ErrReferenceNotFound) {
+		return nil // an unborn branch, e.g. before the initial commit
+	} else if err != nil {
+		return err
+	}
+	s.base = base
+	return nil
+}
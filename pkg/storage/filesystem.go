@@ -0,0 +1,90 @@
+package storage
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+
+	"github.com/go-git/go-billy/v5"
+	"github.com/go-git/go-billy/v5/memfs"
+	"github.com/go-git/go-billy/v5/osfs"
+	billyutil "github.com/go-git/go-billy/v5/util"
+)
+
+// Filesystem is the abstract filesystem the raw storages operate on. It's the subset of a go-billy
+// filesystem the raw storages need, hence e.g. the OS filesystem, an in-memory filesystem, or the
+// filesystem of a go-git worktree can be used.
+type Filesystem interface {
+	billy.Basic
+	billy.Dir
+}
+
+// NewOSFilesystem returns a Filesystem operating on the OS filesystem. Paths are interpreted
+// like by the os package, i.e. relative paths are relative to the working directory.
+func NewOSFilesystem() Filesystem {
+	return &osfs.OS{}
+}
+
+// NewMemoryFilesystem returns a new, empty in-memory Filesystem, e.g. for unit tests.
+// Note that the modification time of its files, and hence their checksum, is always the current time.
+func NewMemoryFilesystem() Filesystem {
+	return memfs.New()
+}
+
+// ReadFile returns the content of the file in the given Filesystem.
+func ReadFile(fs Filesystem, file string) ([]byte, error) {
+	f, err := fs.Open(file)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	return ioutil.ReadAll(f)
+}
+
+// WriteFile writes the content to the file in the given Filesystem, creating the file and its
+// parent directories if needed.
+func WriteFile(fs Filesystem, file string, content []byte) error {
+	if err := fs.MkdirAll(filepath.Dir(file), 0755); err != nil {
+		return err
+	}
+	return billyutil.WriteFile(fs, file, content, 0644)
+}
+
+// WalkFiles returns the paths of the regular files in dir of the given Filesystem, recursively. The
+// directories for which skipDir returns true aren't walked. If dir doesn't exist, nothing is returned.
+func WalkFiles(fs Filesystem, dir string, skipDir func(dir string) bool) ([]string, error) {
+	infos, err := fs.ReadDir(dir)
+	if os.IsNotExist(err) {
+		return nil, nil
+	} else if err != nil {
+		return nil, err
+	}
+
+	var files []string
+	for _, info := range infos {
+		file := fs.Join(dir, info.Name())
+		if !info.IsDir() {
+			files = append(files, file)
+			continue
+		}
+		if skipDir != nil && skipDir(file) {
+			continue
+		}
+		dirFiles, err := WalkFiles(fs, file, skipDir)
+		if err != nil {
+			return nil, err
+		}
+		files = append(files, dirFiles...)
+	}
+	return files, nil
+}
+
+func removeAll(fs Filesystem, path string) error {
+	return billyutil.RemoveAll(fs, path)
+}
+
+// fileExists returns true if a regular file, not a directory, exists at the given path
+func fileExists(fs Filesystem, file string) bool {
+	info, err := fs.Stat(file)
+	return err == nil && !info.IsDir()
+}
@@ -2,14 +2,11 @@ package storage
 
 import (
 	"fmt"
-	"io/ioutil"
-	"os"
 	"path/filepath"
 	"sync"
 
 	log "github.com/sirupsen/logrus"
 	"github.com/weaveworks/libgitops/pkg/serializer"
-	"github.com/weaveworks/libgitops/pkg/util"
 )
 
 var (
@@ -27,25 +24,51 @@ type MappedRawStorage interface {
 	// RemoveMapping removes the physical file
 	// path mapping matching the given Key
 	RemoveMapping(key ObjectKey)
+	// GetMapping returns the physical file path bound to the given Key.
+	// If the Key isn't mapped, returns ErrNotFound + ErrNotTracked.
+	GetMapping(key ObjectKey) (string, error)
 
 	// SetMappings overwrites all known mappings
 	SetMappings(m map[ObjectKey]string)
 }
 
+// NewFilePathFunc returns the physical file path for a new, not yet mapped Key.
+// An error is returned if no file may be created for the given Key.
+type NewFilePathFunc func(key ObjectKey) (string, error)
+
 func NewGenericMappedRawStorage(dir string) MappedRawStorage {
+	return NewGenericMappedRawStorageWithNewFilePath(dir, nil)
+}
+
+// NewGenericMappedRawStorageWithNewFilePath creates a GenericMappedRawStorage that allows
+// creating new files for unmapped Keys, using newFilePath to place them. The created
+// files are mapped automatically.
+func NewGenericMappedRawStorageWithNewFilePath(dir string, newFilePath NewFilePathFunc) MappedRawStorage {
+	return NewGenericMappedRawStorageWithFilesystem(NewOSFilesystem(), dir, newFilePath)
+}
+
+// NewGenericMappedRawStorageWithFilesystem is like NewGenericMappedRawStorageWithNewFilePath, but the
+// mapped files are paths in the given Filesystem, e.g. an in-memory one, instead of the OS filesystem.
+// newFilePath is optional.
+func NewGenericMappedRawStorageWithFilesystem(fs Filesystem, dir string, newFilePath NewFilePathFunc) MappedRawStorage {
 	return &GenericMappedRawStorage{
+		fs:           fs,
 		dir:          dir,
 		fileMappings: make(map[ObjectKey]string),
 		mux:          &sync.Mutex{},
+		newFilePath:  newFilePath,
 	}
 }
 
 // GenericMappedRawStorage is the default implementation of a MappedRawStorage,
 // it stores files in the given directory via a path translation map.
 type GenericMappedRawStorage struct {
+	fs           Filesystem
 	dir          string
 	fileMappings map[ObjectKey]string
 	mux          *sync.Mutex
+	// newFilePath is optional, and places files for unmapped Keys
+	newFilePath NewFilePathFunc
 }
 
 func (r *GenericMappedRawStorage) realPath(key ObjectKey) (string, error) {
@@ -59,6 +82,21 @@ func (r *GenericMappedRawStorage) realPath(key ObjectKey) (string, error) {
 	return path, nil
 }
 
+// writePath returns the mapped path of the key, or the path of a new
+// file for the key in case it's unmapped and newFilePath is set.
+func (r *GenericMappedRawStorage) writePath(key ObjectKey) (path string, isNew bool, err error) {
+	path, err = r.realPath(key)
+	if err == nil || r.newFilePath == nil {
+		return
+	}
+
+	if path, err = r.newFilePath(key); err != nil {
+		return "", false, fmt.Errorf("GenericMappedRawStorage: cannot place %q: %w", key, err)
+	}
+
+	return path, true, nil
+}
+
 // If the file doesn't exist, returns ErrNotFound + ErrNotTracked.
 func (r *GenericMappedRawStorage) Read(key ObjectKey) ([]byte, error) {
 	file, err := r.realPath(key)
@@ -66,7 +104,7 @@ func (r *GenericMappedRawStorage) Read(key ObjectKey) ([]byte, error) {
 		return nil, err
 	}
 
-	return ioutil.ReadFile(file)
+	return ReadFile(r.fs, file)
 }
 
 func (r *GenericMappedRawStorage) Exists(key ObjectKey) bool {
@@ -75,18 +113,33 @@ func (r *GenericMappedRawStorage) Exists(key ObjectKey) bool {
 		return false
 	}
 
-	return util.FileExists(file)
+	return fileExists(r.fs, file)
 }
 
 func (r *GenericMappedRawStorage) Write(key ObjectKey, content []byte) error {
-	// GenericMappedRawStorage isn't going to generate files itself,
-	// only write if the file is already known
-	file, err := r.realPath(key)
+	// GenericMappedRawStorage isn't going to generate files itself, only write
+	// if the file is already known, or newFilePath allows placing a new file
+	file, isNew, err := r.writePath(key)
 	if err != nil {
 		return err
 	}
 
-	return ioutil.WriteFile(file, content, 0644)
+	if isNew {
+		// Create the underlying directories if they do not exist already
+		if err := r.fs.MkdirAll(filepath.Dir(file), 0755); err != nil {
+			return err
+		}
+	}
+
+	if err := WriteFile(r.fs, file, content); err != nil {
+		return err
+	}
+
+	if isNew {
+		r.AddMapping(key, file)
+	}
+
+	return nil
 }
 
 // If the file doesn't exist, returns ErrNotFound + ErrNotTracked.
@@ -98,8 +151,8 @@ func (r *GenericMappedRawStorage) Delete(key ObjectKey) (err error) {
 
 	// GenericMappedRawStorage files can be deleted
 	// externally, check that the file exists first
-	if util.FileExists(file) {
-		err = os.Remove(file)
+	if fileExists(r.fs, file) {
+		err = r.fs.Remove(file)
 	}
 
 	if err == nil {
@@ -130,11 +183,11 @@ func (r *GenericMappedRawStorage) Checksum(key ObjectKey) (string, error) {
 		return "", err
 	}
 
-	return checksumFromModTime(path)
+	return checksumFromModTime(r.fs, path)
 }
 
 func (r *GenericMappedRawStorage) ContentType(key ObjectKey) (ct serializer.ContentType) {
-	if file, err := r.realPath(key); err == nil {
+	if file, _, err := r.writePath(key); err == nil {
 		ct = ContentTypes[filepath.Ext(file)] // Retrieve the correct format based on the extension
 	}
 
@@ -169,6 +222,10 @@ func (r *GenericMappedRawStorage) RemoveMapping(key ObjectKey) {
 	r.mux.Unlock()
 }
 
+func (r *GenericMappedRawStorage) GetMapping(key ObjectKey) (string, error) {
+	return r.realPath(key)
+}
+
 func (r *GenericMappedRawStorage) SetMappings(m map[ObjectKey]string) {
 	log.Debugf("GenericMappedRawStorage: SetMappings: %v", m)
 	r.mux.Lock()
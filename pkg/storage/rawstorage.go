@@ -2,7 +2,6 @@ package storage
 
 import (
 	"fmt"
-	"io/ioutil"
 	"os"
 	"path"
 	"path/filepath"
@@ -11,7 +10,6 @@ import (
 
 	"github.com/weaveworks/libgitops/pkg/runtime"
 	"github.com/weaveworks/libgitops/pkg/serializer"
-	"github.com/weaveworks/libgitops/pkg/util"
 	"k8s.io/apimachinery/pkg/runtime/schema"
 )
 
@@ -46,11 +44,18 @@ type RawStorage interface {
 }
 
 func NewGenericRawStorage(dir string, gv schema.GroupVersion, ct serializer.ContentType) RawStorage {
+	return NewGenericRawStorageWithFilesystem(NewOSFilesystem(), dir, gv, ct)
+}
+
+// NewGenericRawStorageWithFilesystem is like NewGenericRawStorage, but stores the files in
+// dir of the given Filesystem, e.g. an in-memory one, instead of the OS filesystem.
+func NewGenericRawStorageWithFilesystem(fs Filesystem, dir string, gv schema.GroupVersion, ct serializer.ContentType) RawStorage {
 	ext := extForContentType(ct)
 	if ext == "" {
 		panic("Invalid content type")
 	}
 	return &GenericRawStorage{
+		fs:  fs,
 		dir: dir,
 		gv:  gv,
 		ct:  ct,
@@ -63,6 +68,7 @@ func NewGenericRawStorage(dir string, gv schema.GroupVersion, ct serializer.Cont
 // The GenericRawStorage only supports one GroupVersion at a time, and will error if given
 // any other resources
 type GenericRawStorage struct {
+	fs  Filesystem
 	dir string
 	gv  schema.GroupVersion
 	ct  serializer.ContentType
@@ -96,7 +102,7 @@ func (r *GenericRawStorage) Read(key ObjectKey) ([]byte, error) {
 		return nil, ErrNotFound
 	}
 
-	return ioutil.ReadFile(r.keyPath(key))
+	return ReadFile(r.fs, r.keyPath(key))
 }
 
 func (r *GenericRawStorage) Exists(key ObjectKey) bool {
@@ -105,7 +111,7 @@ func (r *GenericRawStorage) Exists(key ObjectKey) bool {
 		return false
 	}
 
-	return util.FileExists(r.keyPath(key))
+	return fileExists(r.fs, r.keyPath(key))
 }
 
 func (r *GenericRawStorage) Write(key ObjectKey, content []byte) error {
@@ -118,12 +124,12 @@ func (r *GenericRawStorage) Write(key ObjectKey, content []byte) error {
 
 	// Create the underlying directories if they do not exist already
 	if !r.Exists(key) {
-		if err := os.MkdirAll(path.Dir(file), 0755); err != nil {
+		if err := r.fs.MkdirAll(path.Dir(file), 0755); err != nil {
 			return err
 		}
 	}
 
-	return ioutil.WriteFile(file, content, 0644)
+	return WriteFile(r.fs, file, content)
 }
 
 func (r *GenericRawStorage) Delete(key ObjectKey) error {
@@ -137,7 +143,7 @@ func (r *GenericRawStorage) Delete(key ObjectKey) error {
 		return ErrNotFound
 	}
 
-	return os.RemoveAll(path.Dir(r.keyPath(key)))
+	return removeAll(r.fs, path.Dir(r.keyPath(key)))
 }
 
 func (r *GenericRawStorage) List(kind KindKey) ([]ObjectKey, error) {
@@ -146,7 +152,7 @@ func (r *GenericRawStorage) List(kind KindKey) ([]ObjectKey, error) {
 		return nil, err
 	}
 
-	entries, err := ioutil.ReadDir(r.kindKeyPath(kind))
+	entries, err := r.fs.ReadDir(r.kindKeyPath(kind))
 	if err != nil {
 		return nil, err
 	}
@@ -172,7 +178,7 @@ func (r *GenericRawStorage) Checksum(key ObjectKey) (string, error) {
 		return "", ErrNotFound
 	}
 
-	return checksumFromModTime(r.keyPath(key))
+	return checksumFromModTime(r.fs, r.keyPath(key))
 }
 
 func (r *GenericRawStorage) ContentType(_ ObjectKey) serializer.ContentType {
@@ -207,8 +213,8 @@ func (r *GenericRawStorage) GetKey(p string) (ObjectKey, error) {
 	return NewObjectKey(NewKindKey(gvk), runtime.NewIdentifier(uid)), nil
 }
 
-func checksumFromModTime(path string) (string, error) {
-	fi, err := os.Stat(path)
+func checksumFromModTime(fs Filesystem, path string) (string, error) {
+	fi, err := fs.Stat(path)
 	if err != nil {
 		return "", err
 	}
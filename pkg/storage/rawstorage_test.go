@@ -0,0 +1,44 @@
+package storage
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/weaveworks/libgitops/pkg/runtime"
+	"github.com/weaveworks/libgitops/pkg/serializer"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+)
+
+func TestInMemoryRawStorages(t *testing.T) {
+	gv := schema.GroupVersion{Group: "sample-app.weave.works", Version: "v1alpha1"}
+	kind := NewKindKey(gv.WithKind("Car"))
+	key := NewObjectKey(kind, runtime.NewIdentifier("foo"))
+	content := []byte("kind: Car")
+
+	raw := NewGenericRawStorageWithFilesystem(NewMemoryFilesystem(), "/manifests", gv, serializer.ContentTypeYAML)
+	mapped := NewGenericMappedRawStorageWithFilesystem(NewMemoryFilesystem(), "/manifests", func(key ObjectKey) (string, error) {
+		return "/manifests/" + key.GetIdentifier() + ".yaml", nil
+	})
+	for name, r := range map[string]RawStorage{"GenericRawStorage": raw, "GenericMappedRawStorage": mapped} {
+		t.Run(name, func(t *testing.T) {
+			if err := r.Write(key, content); err != nil {
+				t.Fatal(err)
+			}
+			if got, err := r.Read(key); err != nil || string(got) != string(content) {
+				t.Errorf("Read() = %q, %v, want %q", got, err, content)
+			}
+			if keys, err := r.List(kind); err != nil || len(keys) != 1 || keys[0].GetIdentifier() != "foo" {
+				t.Errorf("List() = %v, %v, want [foo]", keys, err)
+			}
+			if err := r.Delete(key); err != nil {
+				t.Fatal(err)
+			}
+			if r.Exists(key) {
+				t.Error("Exists() after Delete() = true")
+			}
+			if _, err := r.Read(key); !errors.Is(err, ErrNotFound) {
+				t.Errorf("Read() after Delete() error = %v, want ErrNotFound", err)
+			}
+		})
+	}
+}
@@ -2,10 +2,44 @@ package transaction
 
 import (
 	"fmt"
+	"strings"
+	"time"
 
 	"github.com/fluxcd/go-git-providers/validation"
 )
 
+// Commonly used trailer keys
+const (
+	TrailerSignedOffBy  = "Signed-off-by"
+	TrailerCoAuthoredBy = "Co-authored-by"
+	TrailerChangeID     = "Change-Id"
+)
+
+// Trailer is a "Key: Value" line appended to a commit message.
+type Trailer struct {
+	// Key of the trailer, e.g. "Signed-off-by". It can't contain whitespace or colons.
+	// +required
+	Key string
+	// Value of the trailer, e.g. "John Doe <john@example.com>". It can't contain newlines.
+	// +required
+	Value string
+}
+
+// NewIdentityTrailer returns a trailer with the given key, crediting the person with the given
+// name and email, e.g. for TrailerSignedOffBy or TrailerCoAuthoredBy.
+func NewIdentityTrailer(key, name, email string) Trailer {
+	return Trailer{Key: key, Value: fmt.Sprintf("%s <%s>", name, email)}
+}
+
+func (t Trailer) String() string {
+	return fmt.Sprintf("%s: %s", t.Key, t.Value)
+}
+
+func (t Trailer) isValid() bool {
+	return len(t.Key) != 0 && !strings.ContainsAny(t.Key, ": \t\r\n") &&
+		len(strings.TrimSpace(t.Value)) != 0 && !strings.ContainsAny(t.Value, "\r\n")
+}
+
 // CommitResult describes a result of a transaction.
 type CommitResult interface {
 	// GetAuthorName describes the author's name (as per git config)
@@ -27,8 +61,42 @@ type CommitResult interface {
 	Validate() error
 }
 
-// GenericCommitResult implements CommitResult.
+// CommitMetadata can optionally be implemented by a CommitResult, to describe the commit in more detail.
+// It's a separate interface, so that existing CommitResult implementations keep working.
+type CommitMetadata interface {
+	// GetCommitterName describes the committer's name, if different from the author
+	// +optional
+	GetCommitterName() string
+	// GetCommitterEmail describes the committer's email, if different from the author
+	// +optional
+	GetCommitterEmail() string
+	// GetAuthorTime is when the change was authored. If zero, the time of the commit is used.
+	// +optional
+	GetAuthorTime() time.Time
+	// GetCommitterTime is when the commit was created. If zero, the time of the commit is used.
+	// +optional
+	GetCommitterTime() time.Time
+	// GetTrailers are appended to the commit message, e.g. Signed-off-by for DCO checks.
+	// They're expected to be part of CommitResult.GetMessage(), separated by an empty line.
+	// +optional
+	GetTrailers() []Trailer
+}
+
+// commitMetadataFor returns the CommitMetadata of the result, if implemented
+func commitMetadataFor(result CommitResult) (CommitMetadata, bool) {
+	if metadata, ok := result.(CommitMetadata); ok {
+		return metadata, true
+	}
+	// The embedded CommitResult of a GenericPullRequestResult may implement it
+	if pr, ok := result.(*GenericPullRequestResult); ok && pr.CommitResult != nil {
+		return commitMetadataFor(pr.CommitResult)
+	}
+	return nil, false
+}
+
+// GenericCommitResult implements CommitResult and CommitMetadata.
 var _ CommitResult = &GenericCommitResult{}
+var _ CommitMetadata = &GenericCommitResult{}
 
 // GenericCommitResult implements CommitResult.
 type GenericCommitResult struct {
@@ -44,6 +112,21 @@ type GenericCommitResult struct {
 	// Description contains optional extra information about the change.
 	// +optional
 	Description string
+	// CommitterName describes the committer's name, if different from the author
+	// +optional
+	CommitterName string
+	// CommitterEmail describes the committer's email, if different from the author
+	// +optional
+	CommitterEmail string
+	// AuthorTime is when the change was authored. If zero, the time of the commit is used.
+	// +optional
+	AuthorTime time.Time
+	// CommitterTime is when the commit was created. If zero, the time of the commit is used.
+	// +optional
+	CommitterTime time.Time
+	// Trailers are appended to the commit message, e.g. Signed-off-by for DCO checks.
+	// +optional
+	Trailers []Trailer
 }
 
 func (r *GenericCommitResult) GetAuthorName() string {
@@ -58,11 +141,35 @@ func (r *GenericCommitResult) GetTitle() string {
 func (r *GenericCommitResult) GetDescription() string {
 	return r.Description
 }
+func (r *GenericCommitResult) GetCommitterName() string {
+	return r.CommitterName
+}
+func (r *GenericCommitResult) GetCommitterEmail() string {
+	return r.CommitterEmail
+}
+func (r *GenericCommitResult) GetAuthorTime() time.Time {
+	return r.AuthorTime
+}
+func (r *GenericCommitResult) GetCommitterTime() time.Time {
+	return r.CommitterTime
+}
+func (r *GenericCommitResult) GetTrailers() []Trailer {
+	return r.Trailers
+}
 func (r *GenericCommitResult) GetMessage() string {
-	if len(r.Description) == 0 {
-		return r.Title
+	msg := r.Title
+	if len(r.Description) != 0 {
+		msg = fmt.Sprintf("%s\n%s", msg, r.Description)
+	}
+	if len(r.Trailers) == 0 {
+		return msg
 	}
-	return fmt.Sprintf("%s\n%s", r.Title, r.Description)
+	// Git only recognizes trailers in the last paragraph of the message
+	trailers := make([]string, 0, len(r.Trailers))
+	for _, t := range r.Trailers {
+		trailers = append(trailers, t.String())
+	}
+	return fmt.Sprintf("%s\n\n%s", msg, strings.Join(trailers, "\n"))
 }
 func (r *GenericCommitResult) Validate() error {
 	v := validation.New("GenericCommitResult")
@@ -75,5 +182,17 @@ func (r *GenericCommitResult) Validate() error {
 	if len(r.Title) == 0 {
 		v.Required("Title")
 	}
+	// The committer identity is either fully set, or not at all
+	if len(r.CommitterName) != 0 && len(r.CommitterEmail) == 0 {
+		v.Required("CommitterEmail")
+	}
+	if len(r.CommitterEmail) != 0 && len(r.CommitterName) == 0 {
+		v.Required("CommitterName")
+	}
+	for i, t := range r.Trailers {
+		if !t.isValid() {
+			v.Invalid(t.String(), fmt.Sprintf("Trailers[%d]", i))
+		}
+	}
 	return v.Error()
 }
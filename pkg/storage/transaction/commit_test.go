@@ -0,0 +1,107 @@
+package transaction
+
+import (
+	"testing"
+	"time"
+)
+
+func TestGenericCommitResult(t *testing.T) {
+	tests := []struct {
+		name    string
+		result  GenericCommitResult
+		wantMsg string
+		wantErr bool
+	}{
+		{
+			name:    "title only",
+			result:  GenericCommitResult{AuthorName: "Bot", AuthorEmail: "bot@example.com", Title: "Update foo"},
+			wantMsg: "Update foo",
+		},
+		{
+			name: "trailers",
+			result: GenericCommitResult{
+				AuthorName:  "Bot",
+				AuthorEmail: "bot@example.com",
+				Title:       "Update foo",
+				Description: "Bumps the replicas.",
+				Trailers: []Trailer{
+					NewIdentityTrailer(TrailerSignedOffBy, "Bot", "bot@example.com"),
+					{Key: TrailerChangeID, Value: "I1234"},
+				},
+			},
+			wantMsg: "Update foo\nBumps the replicas.\n\nSigned-off-by: Bot <bot@example.com>\nChange-Id: I1234",
+		},
+		{
+			name:    "committer without email",
+			result:  GenericCommitResult{AuthorName: "Bot", AuthorEmail: "bot@example.com", Title: "Update foo", CommitterName: "CI"},
+			wantMsg: "Update foo",
+			wantErr: true,
+		},
+		{
+			name: "invalid trailer key",
+			result: GenericCommitResult{
+				AuthorName:  "Bot",
+				AuthorEmail: "bot@example.com",
+				Title:       "Update foo",
+				Trailers:    []Trailer{{Key: "Signed off by", Value: "Bot"}},
+			},
+			wantMsg: "Update foo\n\nSigned off by: Bot",
+			wantErr: true,
+		},
+		{
+			name: "multi-line trailer value",
+			result: GenericCommitResult{
+				AuthorName:  "Bot",
+				AuthorEmail: "bot@example.com",
+				Title:       "Update foo",
+				Trailers:    []Trailer{{Key: TrailerChangeID, Value: "I1234\nI5678"}},
+			},
+			wantMsg: "Update foo\n\nChange-Id: I1234\nI5678",
+			wantErr: true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := tt.result.GetMessage(); got != tt.wantMsg {
+				t.Errorf("GetMessage() = %q, want %q", got, tt.wantMsg)
+			}
+			if err := tt.result.Validate(); (err != nil) != tt.wantErr {
+				t.Errorf("Validate() error = %v, wantErr %v", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+// legacyCommitResult only implements CommitResult, not CommitMetadata
+type legacyCommitResult struct {
+	CommitResult
+}
+
+func TestCommitSpecFor(t *testing.T) {
+	authored := time.Date(2020, 1, 1, 0, 0, 0, 0, time.UTC)
+	result := &GenericCommitResult{
+		AuthorName:     "Dev",
+		AuthorEmail:    "dev@example.com",
+		Title:          "Update foo",
+		CommitterName:  "Bot",
+		CommitterEmail: "bot@example.com",
+		AuthorTime:     authored,
+	}
+
+	// The metadata is used, also when embedded in a GenericPullRequestResult
+	for _, r := range []CommitResult{result, &GenericPullRequestResult{CommitResult: result}} {
+		spec := commitSpecFor(r)
+		if spec.Author.Name != "Dev" || !spec.Author.When.Equal(authored) {
+			t.Errorf("commitSpecFor(%T) author = %+v, want Dev at %s", r, spec.Author, authored)
+		}
+		if spec.Committer == nil || spec.Committer.Name != "Bot" || spec.Committer.Email != "bot@example.com" {
+			t.Errorf("commitSpecFor(%T) committer = %+v, want Bot", r, spec.Committer)
+		}
+	}
+
+	// Without metadata, the author is the committer, and the times are the time of the commit
+	spec := commitSpecFor(&legacyCommitResult{CommitResult: result})
+	if spec.Author.Name != "Dev" || !spec.Author.When.IsZero() || spec.Committer != nil || spec.Message != "Update foo" {
+		t.Errorf("commitSpecFor() without metadata = %+v, want the author only", spec)
+	}
+}
@@ -0,0 +1,149 @@
+package transaction
+
+import (
+	"path/filepath"
+	"sort"
+
+	"github.com/sirupsen/logrus"
+	"github.com/weaveworks/libgitops/pkg/gitdir"
+	"github.com/weaveworks/libgitops/pkg/runtime"
+	"github.com/weaveworks/libgitops/pkg/serializer"
+	"github.com/weaveworks/libgitops/pkg/storage"
+	"github.com/weaveworks/libgitops/pkg/storage/watch/update"
+)
+
+// GitStorage implements update.EventStorage.
+var _ update.EventStorage = &GitStorage{}
+
+// SetUpdateStream gives the GitStorage a channel to send events to. Events are sent for
+// the Objects changed by new commits on the main branch, with the commit SHA as the
+// revision. Renamed files are sent as MODIFY events.
+func (s *GitStorage) SetUpdateStream(eventStream update.UpdateStream) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.events = eventStream
+}
+
+// Create is not supported outside of a transaction, ErrNotInTransaction is returned.
+func (s *GitStorage) Create(_ runtime.Object) error {
+	return ErrNotInTransaction
+}
+
+// Update is not supported outside of a transaction, ErrNotInTransaction is returned.
+func (s *GitStorage) Update(_ runtime.Object) error {
+	return ErrNotInTransaction
+}
+
+// Patch is not supported outside of a transaction, ErrNotInTransaction is returned.
+func (s *GitStorage) Patch(_ storage.ObjectKey, _ []byte) error {
+	return ErrNotInTransaction
+}
+
+// Delete is not supported outside of a transaction, ErrNotInTransaction is returned.
+func (s *GitStorage) Delete(_ storage.ObjectKey) error {
+	return ErrNotInTransaction
+}
+
+// sendEvents sends events for the Objects in the files changed between the two commits
+func (s *GitStorage) sendEvents(oldCommit, newCommit string, changes []gitdir.FileChange, oldMappings, newMappings map[storage.ObjectKey]string) {
+	s.mu.Lock()
+	events := s.events
+	s.mu.Unlock()
+	if events == nil {
+		return
+	}
+
+	// Collect the keys of the Objects in the changed files, in order
+	oldKeys, newKeys := invertMappings(oldMappings), invertMappings(newMappings)
+	keys := make([]storage.ObjectKey, 0, len(changes))
+	seen := make(map[storage.ObjectKey]bool, len(changes))
+	addKey := func(keyForFile map[string]storage.ObjectKey, path string) {
+		if len(path) == 0 {
+			return
+		}
+		if key, ok := keyForFile[s.repoPath(path)]; ok && !seen[key] {
+			seen[key] = true
+			keys = append(keys, key)
+		}
+	}
+	for _, change := range changes {
+		addKey(oldKeys, change.From)
+		addKey(newKeys, change.To)
+	}
+
+	for _, key := range keys {
+		oldFile, inOld := oldMappings[key]
+		newFile, inNew := newMappings[key]
+
+		// The event is based on whether the Object existed before and after, so that
+		// moving an Object to another file results in a MODIFY event
+		event, commit, file := update.ObjectEventModify, newCommit, newFile
+		if !inOld {
+			event = update.ObjectEventCreate
+		} else if !inNew {
+			// Send the Object as it was before the deletion
+			event, commit, file = update.ObjectEventDelete, oldCommit, oldFile
+		}
+
+		partObj, err := s.partialObjectAt(commit, file)
+		if err != nil {
+			logrus.Errorf("GitStorage: couldn't decode %q at %s for %s event: %v", file, commit, event, err)
+			continue
+		}
+
+		logrus.Tracef("GitStorage: Sending %s event for %s at %s", event, key, newCommit)
+		events <- update.Update{
+			Event:         event,
+			PartialObject: partObj,
+			Storage:       s,
+			Revision:      newCommit,
+		}
+	}
+}
+
+// partialObjectAt decodes the file at the given commit into a PartialObject
+func (s *GitStorage) partialObjectAt(commit, file string) (runtime.PartialObject, error) {
+	path, err := filepath.Rel(s.gitDir.Dir(), file)
+	if err != nil {
+		return nil, err
+	}
+	content, err := s.gitDir.ReadFileAtRevision(commit, filepath.ToSlash(path))
+	if err != nil {
+		return nil, err
+	}
+	partObjs, err := storage.DecodePartialObjects(serializer.FromBytes(content), s.Serializer().Scheme(), false, nil)
+	if err != nil {
+		return nil, err
+	}
+	return partObjs[0], nil
+}
+
+// mappedFileChanges returns a change for every file mapped by either of the mappings, in order,
+// to send events for all objects when the files changed between two commits are unknown
+func (s *GitStorage) mappedFileChanges(oldMappings, newMappings map[storage.ObjectKey]string) []gitdir.FileChange {
+	files := make(map[string]bool, len(newMappings))
+	for _, m := range []map[storage.ObjectKey]string{oldMappings, newMappings} {
+		for _, file := range m {
+			files[file] = true
+		}
+	}
+	changes := make([]gitdir.FileChange, 0, len(files))
+	for file := range files {
+		path, err := filepath.Rel(s.gitDir.Dir(), file)
+		if err != nil {
+			continue
+		}
+		path = filepath.ToSlash(path)
+		changes = append(changes, gitdir.FileChange{From: path, To: path})
+	}
+	sort.Slice(changes, func(i, j int) bool { return changes[i].From < changes[j].From })
+	return changes
+}
+
+func invertMappings(m map[storage.ObjectKey]string) map[string]storage.ObjectKey {
+	inverted := make(map[string]storage.ObjectKey, len(m))
+	for key, file := range m {
+		inverted[file] = key
+	}
+	return inverted
+}
@@ -0,0 +1,125 @@
+package transaction
+
+import (
+	"context"
+	"errors"
+	"reflect"
+	"testing"
+
+	"github.com/weaveworks/libgitops/pkg/gitdir"
+	"github.com/weaveworks/libgitops/pkg/storage"
+	"github.com/weaveworks/libgitops/pkg/storage/watch/update"
+)
+
+func TestGitStorageEvents(t *testing.T) {
+	repoDir := newTestRepository(t, map[string]string{
+		"cars/a.yaml": carYAML("a", "volvo"),
+		"cars/c.yaml": carYAML("c", "saab"),
+		"cars/d.yaml": carYAML("d", "audi"),
+	})
+	s := newTestGitStorage(t, repoDir, GitStorageOptions{})
+	events := make(update.UpdateStream, 10)
+	s.SetUpdateStream(events)
+
+	writer := newTestGitDirectory(t, repoDir)
+	commit := commitTestFiles(t, writer, map[string]string{
+		"cars/a.yaml": carYAML("a", "bmw"),   // modified
+		"cars/b.yaml": carYAML("b", "skoda"), // created
+		"cars/c.yaml": "",                    // deleted
+		"cars/d.yaml": "",                    // renamed
+		"cars/e.yaml": carYAML("d", "audi"),
+		"README.md":   "not an object",
+	})
+	syncTestGitStorage(t, s)
+
+	want := map[string]update.ObjectEvent{
+		"a": update.ObjectEventModify,
+		"b": update.ObjectEventCreate,
+		"c": update.ObjectEventDelete,
+		"d": update.ObjectEventModify,
+	}
+	got := map[string]update.ObjectEvent{}
+	for len(events) != 0 {
+		u := <-events
+		if u.Revision != commit {
+			t.Errorf("%s event for %q has revision %s, want %s", u.Event, u.PartialObject.GetName(), u.Revision, commit)
+		}
+		got[u.PartialObject.GetName()] = u.Event
+	}
+	if len(got) != len(want) {
+		t.Errorf("got events %v, want %v", got, want)
+	}
+	for name, event := range want {
+		if got[name] != event {
+			t.Errorf("got %s event for %q, want %s", got[name], name, event)
+		}
+	}
+}
+
+func TestIgnoredCommits(t *testing.T) {
+	repoDir := newTestRepository(t, map[string]string{"cars/a.yaml": carYAML("a", "volvo")})
+	s := newTestGitStorage(t, repoDir, GitStorageOptions{IgnoreAuthors: []string{"test@example.com"}})
+	events := make(update.UpdateStream, 10)
+	s.SetUpdateStream(events)
+
+	// The commit of the ignored author isn't synced
+	writer := newTestGitDirectory(t, repoDir)
+	commitTestFiles(t, writer, map[string]string{"cars/b.yaml": carYAML("b", "saab")})
+	syncTestGitStorage(t, s)
+	if len(events) != 0 {
+		t.Errorf("got %d events for an ignored commit, want none", len(events))
+	}
+	if _, err := carBrand(s, "b"); !errors.Is(err, storage.ErrNotTracked) {
+		t.Errorf("Get(b) after an ignored commit error = %v, want ErrNotTracked", err)
+	}
+
+	// The next commit remaps all objects, but only results in events for its own changes
+	writeTestFiles(t, writer.Dir(), map[string]string{"cars/b.yaml": carYAML("b", "audi")})
+	if err := writer.Commit(context.Background(), gitdir.CommitSpec{
+		Author:  gitdir.Signature{Name: "Dev", Email: "dev@example.com"},
+		Message: "Update b",
+	}); err != nil {
+		t.Fatal(err)
+	}
+	syncTestGitStorage(t, s)
+	if len(events) != 1 {
+		t.Fatalf("got %d events, want 1", len(events))
+	}
+	if u := <-events; u.PartialObject.GetName() != "b" || u.Event != update.ObjectEventModify {
+		t.Errorf("got %s event for %q, want %s for %q", u.Event, u.PartialObject.GetName(), update.ObjectEventModify, "b")
+	}
+	if brand, err := carBrand(s, "b"); err != nil || brand != "audi" {
+		t.Errorf("brand of b = %q, %v, want %q", brand, err, "audi")
+	}
+}
+
+func TestEventsWithoutDiff(t *testing.T) {
+	repoDir := newTestRepository(t, map[string]string{
+		"cars/a.yaml": carYAML("a", "volvo"),
+		"cars/b.yaml": carYAML("b", "saab"),
+	})
+	s := newTestGitStorage(t, repoDir, GitStorageOptions{})
+	events := make(update.UpdateStream, 10)
+	s.SetUpdateStream(events)
+
+	// An unknown last synced commit, e.g. after a force push, can't be diffed against
+	s.mu.Lock()
+	head := s.lastCommit
+	s.lastCommit = "0123456789012345678901234567890123456789"
+	s.mu.Unlock()
+	if err := s.sync(head); err != nil {
+		t.Fatal(err)
+	}
+	if s.lastCommit != head {
+		t.Errorf("last synced commit %s, want %s", s.lastCommit, head)
+	}
+	got := map[string]update.ObjectEvent{}
+	for len(events) != 0 {
+		u := <-events
+		got[u.PartialObject.GetName()] = u.Event
+	}
+	want := map[string]update.ObjectEvent{"a": update.ObjectEventModify, "b": update.ObjectEventModify}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("got events %v, want %v", got, want)
+	}
+}
@@ -0,0 +1,61 @@
+package transaction
+
+import (
+	"path/filepath"
+	"strings"
+
+	"github.com/weaveworks/libgitops/pkg/gitdir"
+	"github.com/weaveworks/libgitops/pkg/util/watcher"
+)
+
+// gitModulesFile describes the submodules of a repository
+const gitModulesFile = ".gitmodules"
+
+// touchesStorage returns true if any of the changed files, relative to the repository root, is
+// considered by the storage, or belongs to a submodule
+func (s *GitStorage) touchesStorage(changedFiles []string) bool {
+	for _, f := range changedFiles {
+		p := filepath.FromSlash(f)
+		if p == gitModulesFile {
+			return true
+		}
+		// The files of a submodule aren't part of the diff, only the submodule itself
+		if _, ok := s.submoduleOfRepoPath(p); ok {
+			return true
+		}
+		if _, ok := s.storageFile(f); ok {
+			return true
+		}
+	}
+	return false
+}
+
+// storageFile returns the absolute path in the main clone for the given path relative to the repository
+// root, and whether the file is considered by the storage
+func (s *GitStorage) storageFile(path string) (string, bool) {
+	p := filepath.FromSlash(path)
+	if !isSubPath(s.relRootDir, p) {
+		return "", false
+	}
+	rel, err := filepath.Rel(s.relRootDir, p)
+	if err != nil {
+		return "", false
+	}
+	file := filepath.Join(s.rootDir, rel)
+	return file, watcher.MatchesOptions(s.rootDir, file, s.walkOpts)
+}
+
+// ignoresCommit returns true if the commit matches GitStorageOptions.IgnoreAuthors or IgnoreMarkers
+func (s *GitStorage) ignoresCommit(commit gitdir.ObservedCommit) bool {
+	for _, author := range s.ignoreAuthors {
+		if author == commit.AuthorName || author == commit.AuthorEmail {
+			return true
+		}
+	}
+	for _, marker := range s.ignoreMarkers {
+		if strings.Contains(commit.Message, marker) {
+			return true
+		}
+	}
+	return false
+}
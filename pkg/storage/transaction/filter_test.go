@@ -0,0 +1,52 @@
+package transaction
+
+import (
+	"testing"
+
+	"github.com/weaveworks/libgitops/pkg/gitdir"
+	"github.com/weaveworks/libgitops/pkg/util/watcher"
+)
+
+func TestCommitFilter(t *testing.T) {
+	s := &GitStorage{
+		rootDir:    "/repo/base",
+		relRootDir: "base",
+		walkOpts: watcher.Options{
+			ExcludeDirs:     excludeDirs,
+			ValidExtensions: []string{".yaml"},
+			Exclude:         []string{"testdata"},
+		},
+		submodules:    []string{"base/vendor"},
+		ignoreAuthors: []string{"bot@example.com"},
+		ignoreMarkers: []string{"[skip sync]"},
+	}
+
+	for _, tt := range []struct {
+		changedFiles []string
+		want         bool
+	}{
+		{changedFiles: []string{"base/a.yaml"}, want: true},
+		{changedFiles: []string{"README.md", "base/README.md"}, want: false},
+		{changedFiles: []string{"other/a.yaml"}, want: false},
+		{changedFiles: []string{"base/testdata/a.yaml"}, want: false},
+		{changedFiles: []string{"base/vendor"}, want: true},
+		{changedFiles: []string{".gitmodules"}, want: true},
+	} {
+		if got := s.touchesStorage(tt.changedFiles); got != tt.want {
+			t.Errorf("touchesStorage(%v) = %t, want %t", tt.changedFiles, got, tt.want)
+		}
+	}
+
+	for _, tt := range []struct {
+		commit gitdir.Commit
+		want   bool
+	}{
+		{commit: gitdir.Commit{AuthorName: "Bot", AuthorEmail: "bot@example.com", Message: "Update a"}, want: true},
+		{commit: gitdir.Commit{AuthorName: "Dev", AuthorEmail: "dev@example.com", Message: "Fix docs [skip sync]"}, want: true},
+		{commit: gitdir.Commit{AuthorName: "Dev", AuthorEmail: "dev@example.com", Message: "Update a"}, want: false},
+	} {
+		if got := s.ignoresCommit(gitdir.ObservedCommit{Commit: tt.commit}); got != tt.want {
+			t.Errorf("ignoresCommit(%+v) = %t, want %t", tt.commit, got, tt.want)
+		}
+	}
+}
@@ -2,38 +2,128 @@ package transaction
 
 import (
 	"context"
+	"errors"
 	"fmt"
+	"path/filepath"
 	"strings"
+	"sync"
 
 	"github.com/sirupsen/logrus"
 	"github.com/weaveworks/libgitops/pkg/gitdir"
 	"github.com/weaveworks/libgitops/pkg/runtime"
 	"github.com/weaveworks/libgitops/pkg/serializer"
 	"github.com/weaveworks/libgitops/pkg/storage"
+	"github.com/weaveworks/libgitops/pkg/storage/watch/update"
 	"github.com/weaveworks/libgitops/pkg/util"
 	"github.com/weaveworks/libgitops/pkg/util/watcher"
 )
 
-var excludeDirs = []string{".git"}
+var (
+	excludeDirs = []string{".git"}
+
+	// ErrRootDirOutOfScope is returned if GitStorageOptions.RootDir is outside of the
+	// scope paths of the GitDirectory.
+	ErrRootDirOutOfScope = errors.New("the root directory isn't part of the scope paths")
+	// ErrExcludedPath is returned if a new object would be placed at a path that isn't
+	// considered by the GitStorage.
+	ErrExcludedPath = errors.New("the path is excluded from the storage")
+)
+
+// GitStorageOptions provides options for the GitStorage.
+type GitStorageOptions struct {
+	// RootDir is the subdirectory of the repository the storage is rooted at.
+	// Only files in this directory are considered. Default: the repository root.
+	RootDir string
+	// Include specifies glob patterns for the files to consider, relative to RootDir.
+	// If empty, all files are included. See watcher.MatchesPatterns for the syntax.
+	Include []string
+	// Exclude specifies glob patterns for the files to ignore, relative to RootDir.
+	// See watcher.MatchesPatterns for the syntax.
+	Exclude []string
+	// NewFilePath returns the path relative to RootDir where a new object should be stored.
+	// The path must not be excluded by the options above. Default: <lowercase kind>/<identifier>.yaml
+	NewFilePath func(key storage.ObjectKey) string
+
+	// IgnoreAuthors are the names or emails of commit authors, e.g. of a bot, whose commits aren't synced,
+	// so that the bot doesn't react to its own changes. No update events are sent for the changes of ignored
+	// commits, and the objects aren't remapped until the next commit that is synced. Until then, the objects
+	// created, moved or deleted by ignored commits aren't found at their new files.
+	IgnoreAuthors []string
+	// IgnoreMarkers are strings like "[skip sync]" that, when contained in a commit message, exclude the
+	// commit from syncing like IgnoreAuthors.
+	IgnoreMarkers []string
+}
+
+func (o *GitStorageOptions) Default() {
+	if o.NewFilePath == nil {
+		o.NewFilePath = defaultNewFilePath
+	}
+}
+
+func defaultNewFilePath(key storage.ObjectKey) string {
+	return filepath.Join(strings.ToLower(key.GetKind()), key.GetIdentifier()+".yaml")
+}
 
 func NewGitStorage(gitDir gitdir.GitDirectory, prProvider PullRequestProvider, ser serializer.Serializer) (TransactionStorage, error) {
+	return NewGitStorageWithOptions(gitDir, prProvider, ser, GitStorageOptions{})
+}
+
+// NewGitStorageWithOptions is like NewGitStorage, but allows rooting the storage at a subdirectory
+// of the repository, and filtering what files are considered.
+func NewGitStorageWithOptions(gitDir gitdir.GitDirectory, prProvider PullRequestProvider, ser serializer.Serializer, opts GitStorageOptions) (TransactionStorage, error) {
+	// Default and validate the options
+	opts.Default()
+	if filepath.IsAbs(opts.RootDir) || strings.HasPrefix(filepath.Clean(opts.RootDir), "..") {
+		return nil, fmt.Errorf("invalid root directory %q: %w", opts.RootDir, gitdir.ErrInvalidPath)
+	}
+
+	// Figure out what paths of the root directory are in scope
+	paths, ok := scopePaths(opts.RootDir, gitDir.ScopePaths())
+	if !ok {
+		return nil, fmt.Errorf("%w: %q", ErrRootDirOutOfScope, opts.RootDir)
+	}
+
 	// Make sure the repo is cloned. If this func has already been called, it will be a no-op.
 	if err := gitDir.StartCheckoutLoop(); err != nil {
 		return nil, err
 	}
 
-	raw := storage.NewGenericMappedRawStorage(gitDir.Dir())
-	s := storage.NewGenericStorage(raw, ser, []runtime.IdentifierFactory{runtime.Metav1NameIdentifier})
+	validExts := make([]string, 0, len(storage.ContentTypes))
+	for ext := range storage.ContentTypes {
+		validExts = append(validExts, ext)
+	}
 
 	gitStorage := &GitStorage{
-		ReadStorage: s,
-		s:           s,
-		raw:         raw,
-		gitDir:      gitDir,
-		prProvider:  prProvider,
-	}
-	// Do a first sync now, and then start the background loop
-	if err := gitStorage.sync(); err != nil {
+		gitDir:     gitDir,
+		prProvider: prProvider,
+		fs:         storage.NewOSFilesystem(),
+		rootDir:    filepath.Join(gitDir.Dir(), opts.RootDir),
+		relRootDir: opts.RootDir,
+		walkOpts: watcher.Options{
+			ExcludeDirs:     excludeDirs,
+			ValidExtensions: validExts,
+			Paths:           paths,
+			Include:         opts.Include,
+			Exclude:         opts.Exclude,
+		},
+		newFilePath:   opts.NewFilePath,
+		ignoreAuthors: opts.IgnoreAuthors,
+		ignoreMarkers: opts.IgnoreMarkers,
+	}
+
+	raw := &readLockedRawStorage{MappedRawStorage: gitStorage.newMappedRawStorage(gitStorage.rootDir), gitDir: gitDir}
+	s := newStorage(raw, ser)
+	gitStorage.ReadStorage = s
+	gitStorage.s = s
+	gitStorage.raw = raw
+
+	// Do a first sync now, and then start the background loop. HEAD is the main branch,
+	// or the followed tag range or commit.
+	head, err := gitDir.ResolveRevision("HEAD")
+	if err != nil {
+		return nil, err
+	}
+	if err := gitStorage.sync(head); err != nil {
 		return nil, err
 	}
 	gitStorage.syncLoop()
@@ -48,14 +138,103 @@ type GitStorage struct {
 	raw        storage.MappedRawStorage
 	gitDir     gitdir.GitDirectory
 	prProvider PullRequestProvider
+	// fs is the filesystem the main clone and the worktrees of transactions are read from and written to
+	fs storage.Filesystem
+
+	// rootDir is the absolute path to the root of the storage, and relRootDir the same path relative
+	// to the repository root
+	rootDir    string
+	relRootDir string
+	// walkOpts specifies what files in rootDir are considered
+	walkOpts watcher.Options
+	// newFilePath places new objects, relative to rootDir
+	newFilePath func(key storage.ObjectKey) string
+	// ignoreAuthors and ignoreMarkers specify the commits that aren't synced
+	ignoreAuthors []string
+	ignoreMarkers []string
+
+	// lastCommit is the last synced commit, and mappings the mappings computed for it.
+	// submodules are the paths of the submodules at the commit, relative to the repository root.
+	// stale is true if ignored commits were skipped since the mappings were computed.
+	// mu guards mappings, submodules and events, which are also used by transactions and the user.
+	lastCommit string
+	mappings   map[storage.ObjectKey]string
+	submodules []string
+	stale      bool
+	mu         sync.Mutex
+	// events is the stream update events are sent to, if set
+	events update.UpdateStream
+}
+
+// newMappedRawStorage creates a MappedRawStorage for the files in rootDir, which is the root directory
+// of the storage either in the main clone, or in the worktree of a transaction
+func (s *GitStorage) newMappedRawStorage(rootDir string) storage.MappedRawStorage {
+	return storage.NewGenericMappedRawStorageWithFilesystem(s.fs, rootDir, func(key storage.ObjectKey) (string, error) {
+		return s.placeNewFile(rootDir, key)
+	})
+}
+
+func newStorage(raw storage.RawStorage, ser serializer.Serializer) storage.Storage {
+	return storage.NewGenericStorage(raw, ser, []runtime.IdentifierFactory{runtime.Metav1NameIdentifier})
+}
+
+// readLockedRawStorage is the MappedRawStorage of the main clone. It reads the files while the worktree
+// is suspended for reading, so that a concurrent pull doesn't check out another commit mid-read.
+type readLockedRawStorage struct {
+	storage.MappedRawStorage
+	gitDir gitdir.GitDirectory
+}
+
+func (r *readLockedRawStorage) Read(key storage.ObjectKey) ([]byte, error) {
+	if err := r.gitDir.SuspendRead(context.Background()); err != nil {
+		return nil, err
+	}
+	defer r.gitDir.ResumeRead()
+	return r.MappedRawStorage.Read(key)
+}
+
+func (r *readLockedRawStorage) Exists(key storage.ObjectKey) bool {
+	if err := r.gitDir.SuspendRead(context.Background()); err != nil {
+		return false
+	}
+	defer r.gitDir.ResumeRead()
+	return r.MappedRawStorage.Exists(key)
+}
+
+func (r *readLockedRawStorage) List(key storage.KindKey) ([]storage.ObjectKey, error) {
+	if err := r.gitDir.SuspendRead(context.Background()); err != nil {
+		return nil, err
+	}
+	defer r.gitDir.ResumeRead()
+	return r.MappedRawStorage.List(key)
+}
+
+func (r *readLockedRawStorage) Checksum(key storage.ObjectKey) (string, error) {
+	if err := r.gitDir.SuspendRead(context.Background()); err != nil {
+		return "", err
+	}
+	defer r.gitDir.ResumeRead()
+	return r.MappedRawStorage.Checksum(key)
+}
+
+// placeNewFile returns the path for a new object in rootDir, and makes sure it's considered by the storage
+func (s *GitStorage) placeNewFile(rootDir string, key storage.ObjectKey) (string, error) {
+	file := filepath.Join(rootDir, s.newFilePath(key))
+	if !watcher.MatchesOptions(rootDir, file, s.walkOpts) {
+		return "", fmt.Errorf("%w: %q", ErrExcludedPath, file)
+	}
+	if submodule, ok := s.submoduleOfRepoPath(filepath.Join(s.relRootDir, s.newFilePath(key))); ok {
+		return "", fmt.Errorf("%w: %q is in submodule %q", ErrSubmoduleFile, file, submodule)
+	}
+	return file, nil
 }
 
 func (s *GitStorage) syncLoop() {
 	go func() {
 		for {
 			if commit, ok := <-s.gitDir.CommitChannel(); ok {
-				logrus.Debugf("GitStorage: Got info about commit %q, syncing...", commit)
-				if err := s.sync(); err != nil {
+				logrus.Debugf("GitStorage: Got info about commit %q, syncing...", commit.Hash)
+				if err := s.syncCommit(commit); err != nil {
 					logrus.Errorf("GitStorage: Got sync error: %v", err)
 				}
 			}
@@ -63,62 +242,303 @@ func (s *GitStorage) syncLoop() {
 	}()
 }
 
-func (s *GitStorage) sync() error {
-	mappings, err := computeMappings(s.gitDir.Dir(), s.s)
+// syncCommit syncs the storage to the observed commit, unless it didn't change any files of the storage.
+// The commits matching IgnoreAuthors or IgnoreMarkers are skipped, leaving the mappings stale.
+func (s *GitStorage) syncCommit(commit gitdir.ObservedCommit) error {
+	s.mu.Lock()
+	lastCommit := s.lastCommit
+	s.mu.Unlock()
+	if commit.Hash == lastCommit {
+		return nil // e.g. the commit of the initial sync
+	}
+
+	// The changed files are only relevant if they're relative to the last synced commit
+	if commit.Previous == lastCommit && commit.ChangedFiles != nil && !s.touchesStorage(commit.ChangedFiles) {
+		logrus.Debugf("GitStorage: Commit %q doesn't change any files of the storage, skipping sync", commit.Hash)
+		s.mu.Lock()
+		s.lastCommit = commit.Hash
+		s.mu.Unlock()
+		return nil
+	}
+	if s.ignoresCommit(commit) {
+		logrus.Debugf("GitStorage: Commit %q is ignored, skipping sync", commit.Hash)
+		s.mu.Lock()
+		s.lastCommit, s.stale = commit.Hash, true
+		s.mu.Unlock()
+		return nil
+	}
+	return s.sync(commit.Hash)
+}
+
+// sync remaps the objects of the storage at the given commit, which must be checked out, and sends
+// update events for the objects that changed since the last synced, or skipped, commit.
+func (s *GitStorage) sync(commit string) error {
+	s.mu.Lock()
+	oldCommit, oldMappings, oldSubmodules, stale := s.lastCommit, s.mappings, s.submodules, s.stale
+	s.mu.Unlock()
+
+	// Only remap the files that changed since the last synced commit, if the changes are known.
+	// The diff is computed before suspending the worktree, as it locks the GitDirectory for reading itself.
+	var changes []gitdir.FileChange
+	var diffErr error
+	if len(oldCommit) != 0 && oldCommit != commit {
+		if changes, diffErr = s.gitDir.Diff(oldCommit, commit); diffErr != nil {
+			logrus.Warnf("GitStorage: Couldn't diff %q and %q, remapping all files and sending events for all objects: %v", oldCommit, commit, diffErr)
+		}
+	}
+	// If ignored commits were skipped, the mappings don't match oldCommit. Hence, all files are remapped,
+	// and the events are based on the objects at oldCommit, as read from the object database.
+	remapChanges, eventMappings := changes, oldMappings
+	if stale {
+		remapChanges = nil
+		if _, raw, err := s.storageAt(oldCommit); err != nil {
+			logrus.Warnf("GitStorage: Couldn't map the objects at %q, using the last synced ones: %v", oldCommit, err)
+		} else {
+			eventMappings = raw.fileMappings
+		}
+	}
+
+	// Don't let a pull modify the worktree while it's read
+	if err := s.gitDir.SuspendRead(context.Background()); err != nil {
+		return err
+	}
+	submodules, mappings, err := s.remap(oldMappings, oldSubmodules, remapChanges)
+	s.gitDir.ResumeRead()
 	if err != nil {
 		return err
 	}
-	logrus.Debugf("Rewriting the mappings to %v", mappings)
-	s.raw.SetMappings(mappings)
+	// The raw storage modifies its mappings, hence give it a copy
+	s.raw.SetMappings(copyMappings(mappings))
+
+	s.mu.Lock()
+	s.lastCommit, s.mappings, s.submodules, s.stale = commit, mappings, submodules, false
+	s.mu.Unlock()
+
+	// Send events for the objects that changed since the last synced commit. If the changed files
+	// are unknown, an event is sent for every object mapped at either commit instead.
+	if len(oldCommit) != 0 && oldCommit != commit {
+		if diffErr != nil {
+			changes = s.mappedFileChanges(eventMappings, mappings)
+		}
+		s.sendEvents(oldCommit, commit, changes, eventMappings, mappings)
+	}
 	return nil
 }
 
-func (s *GitStorage) Transaction(ctx context.Context, streamName string, fn TransactionFunc) error {
-	// Append random bytes to the end of the stream name if it ends with a dash
+// remap returns the submodules and mappings of the checked out worktree. The files in submodules aren't
+// part of changes, hence changed submodules are remapped fully, like when the changes are unknown.
+func (s *GitStorage) remap(oldMappings map[storage.ObjectKey]string, oldSubmodules []string, changes []gitdir.FileChange) ([]string, map[storage.ObjectKey]string, error) {
+	submodules, err := s.gitDir.SubmodulePaths()
+	if err != nil {
+		return nil, nil, err
+	}
+	if changes != nil && equalPaths(submodules, oldSubmodules) && !s.changesSubmodules(changes) {
+		mappings := s.remapChanged(oldMappings, changes)
+		logrus.Debugf("Remapped the %d changed files, the mappings are now %v", len(changes), mappings)
+		return submodules, mappings, nil
+	}
+	mappings, err := computeMappings(s.fs, s.rootDir, s.walkOpts, s.s)
+	if err != nil {
+		return nil, nil, err
+	}
+	logrus.Debugf("Rewriting the mappings to %v", mappings)
+	return submodules, mappings, nil
+}
+
+// remapChanged returns a copy of the mappings updated for the changed files. Only the added, modified
+// and renamed files are read and decoded, and the objects of the deleted files are removed.
+func (s *GitStorage) remapChanged(mappings map[storage.ObjectKey]string, changes []gitdir.FileChange) map[storage.ObjectKey]string {
+	changed := make(map[string]bool, len(changes))
+	files := make([]string, 0, len(changes))
+	for _, change := range changes {
+		if len(change.From) != 0 {
+			changed[s.repoPath(change.From)] = true
+		}
+		if len(change.To) != 0 {
+			changed[s.repoPath(change.To)] = true
+			if file, ok := s.storageFile(change.To); ok {
+				files = append(files, file)
+			}
+		}
+	}
+
+	m := make(map[storage.ObjectKey]string, len(mappings))
+	for key, file := range mappings {
+		if !changed[file] {
+			m[key] = file
+		}
+	}
+	for key, file := range mapFiles(files, s.readFile, s.s) {
+		m[key] = file
+	}
+	return m
+}
+
+// changesSubmodules returns true if any of the changes affects the submodules of the repository
+func (s *GitStorage) changesSubmodules(changes []gitdir.FileChange) bool {
+	for _, change := range changes {
+		for _, path := range []string{change.From, change.To} {
+			if len(path) == 0 {
+				continue
+			}
+			if _, ok := s.submoduleOfRepoPath(filepath.FromSlash(path)); ok || path == gitModulesFile {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+func equalPaths(a, b []string) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for i := range a {
+		if a[i] != b[i] {
+			return false
+		}
+	}
+	return true
+}
+
+func copyMappings(m map[storage.ObjectKey]string) map[storage.ObjectKey]string {
+	c := make(map[storage.ObjectKey]string, len(m))
+	for key, file := range m {
+		c[key] = file
+	}
+	return c
+}
+
+func (s *GitStorage) Transaction(ctx context.Context, streamName string, fn TransactionFunc) (*PullRequest, error) {
+	return s.randomTransaction(ctx, streamName, func(ctx context.Context, tx *txWorktree) (CommitResult, error) {
+		return fn(ctx, tx.s)
+	})
+}
+
+// randomTransaction runs fn in a transaction on a new branch with the given name, appending
+// random bytes to it if it ends with a dash
+func (s *GitStorage) randomTransaction(ctx context.Context, streamName string, fn func(context.Context, *txWorktree) (CommitResult, error)) (*PullRequest, error) {
 	if strings.HasSuffix(streamName, "-") {
 		suffix, err := util.RandomSHA(4)
 		if err != nil {
-			return err
+			return nil, err
 		}
 		streamName += suffix
 	}
+	return s.transaction(ctx, streamName, nil, fn)
+}
 
+// UpsertOptions configures UpsertTransaction.
+type UpsertOptions struct {
+	// Recreate re-creates the stream branch from the main branch and force-pushes it, instead of
+	// adding the new commit on top of the existing branch. This keeps the Pull Request up-to-date
+	// with the main branch, but discards the earlier commits on the stream branch.
+	Recreate bool
+}
+
+// UpsertTransaction is like Transaction, but streamName is used as-is, and may refer to an existing
+// stream. If the branch exists on the remote, it's fetched and the new commit is added on top of it,
+// or it's re-created if opts.Recreate is set. If a Pull Request is asked for, and there's already
+// an open Pull Request for the branch, it's updated to match the PullRequestResult and returned.
+// If another transaction commits to the branch in the meantime, gitdir.ErrBranchChanged is returned,
+// and the transaction can be retried on top of the other one.
+func (s *GitStorage) UpsertTransaction(ctx context.Context, streamName string, opts UpsertOptions, fn TransactionFunc) (*PullRequest, error) {
+	return s.transaction(ctx, streamName, &opts, func(ctx context.Context, tx *txWorktree) (CommitResult, error) {
+		return fn(ctx, tx.s)
+	})
+}
+
+// txWorktree is the worktree of a transaction, with a Storage for its files. Objects in submodules are
+// mapped to their files in the main clone, see submoduleGuardRawStorage.
+type txWorktree struct {
+	gitdir.Worktree
+	s   storage.Storage
+	raw storage.MappedRawStorage
+}
+
+// repoPath returns the absolute path in the worktree for the given path relative to the repository root
+func (w *txWorktree) repoPath(path string) string {
+	return filepath.Join(w.Dir(), filepath.FromSlash(path))
+}
+
+// newTxWorktree creates a Storage for the files in the worktree, rooted and filtered like the main storage
+func (s *GitStorage) newTxWorktree(wt gitdir.Worktree) (*txWorktree, error) {
+	rel, err := filepath.Rel(s.gitDir.Dir(), s.rootDir)
+	if err != nil {
+		return nil, err
+	}
+	rootDir := filepath.Join(wt.Dir(), rel)
+	raw := &submoduleGuardRawStorage{MappedRawStorage: s.newMappedRawStorage(rootDir), s: s}
+	txStorage := newStorage(raw, s.s.Serializer())
+	mappings, err := computeMappings(s.fs, rootDir, s.walkOpts, txStorage)
+	if err != nil {
+		return nil, err
+	}
+	for key, file := range s.submoduleMappings() {
+		if _, ok := mappings[key]; !ok {
+			mappings[key] = file
+		}
+	}
+	raw.SetMappings(mappings)
+	return &txWorktree{Worktree: wt, s: txStorage, raw: raw}, nil
+}
+
+// transaction runs fn on a new branch with the given name. If upsert is set, an existing branch
+// with the same name is continued from, or re-created, instead. The branch is checked out in its
+// own worktree, so that multiple transactions can run concurrently, and the main storage always
+// reflects the main branch.
+func (s *GitStorage) transaction(ctx context.Context, streamName string, upsert *UpsertOptions, fn func(context.Context, *txWorktree) (CommitResult, error)) (*PullRequest, error) {
 	// Make sure we have the latest available state
 	if err := s.gitDir.Pull(ctx); err != nil {
-		return err
+		return nil, err
 	}
-	// Make sure no other Git ops can take place during the transaction, wait for other ongoing operations.
-	s.gitDir.Suspend()
-	defer s.gitDir.Resume()
-	// Always switch back to the main branch afterwards.
-	// TODO ordering of the defers, and return deferred error
-	defer func() { _ = s.gitDir.CheckoutMainBranch() }()
 
-	// Check out a new branch with the given name
-	if err := s.gitDir.CheckoutNewBranch(streamName); err != nil {
-		return err
+	// Check out a new branch with the given name from the main branch, or the existing one when upserting
+	wt, exists, err := s.gitDir.OpenWorktree(ctx, streamName, upsert == nil || upsert.Recreate)
+	if err != nil {
+		return nil, err
+	}
+	defer func() { _ = wt.Cleanup() }()
+	if upsert == nil {
+		exists = false // a plain transaction never continues an existing branch
+	}
+	tx, err := s.newTxWorktree(wt)
+	if err != nil {
+		return nil, err
 	}
+
 	// Invoke the transaction
-	result, err := fn(ctx, s.s)
+	result, err := fn(ctx, tx)
 	if err != nil {
-		return err
+		return nil, err
 	}
 	// Make sure the result is valid
 	if err := result.Validate(); err != nil {
-		return fmt.Errorf("transaction result is not valid: %w", err)
+		return nil, fmt.Errorf("transaction result is not valid: %w", err)
 	}
-	// Perform the commit
-	if err := s.gitDir.Commit(ctx, result.GetAuthorName(), result.GetAuthorEmail(), result.GetMessage()); err != nil {
-		return err
+	// Perform the commit. A re-created branch replaces the existing one on the remote.
+	spec := commitSpecFor(result)
+	spec.ForcePush = exists && upsert.Recreate
+	if err := wt.Commit(ctx, spec); err != nil {
+		return nil, err
 	}
 	// Return if no PR should be made
 	prResult, ok := result.(PullRequestResult)
 	if !ok {
-		return nil
+		return nil, nil
 	}
 	// If a PR was asked for, and no provider was given, error out
 	if s.prProvider == nil {
-		return ErrNoPullRequestProvider
+		return nil, ErrNoPullRequestProvider
+	}
+	// Update the open PR of an existing branch, if any
+	if exists {
+		pr, err := s.prProvider.FindPullRequest(ctx, s.gitDir.RepositoryRef(), streamName)
+		if err == nil {
+			return s.prProvider.UpdatePullRequest(ctx, pr, prResult)
+		} else if !errors.Is(err, ErrPullRequestNotFound) {
+			return nil, err
+		}
 	}
 	// Create the PR using the provider.
 	return s.prProvider.CreatePullRequest(ctx, &GenericPullRequestSpec{
@@ -129,22 +549,84 @@ func (s *GitStorage) Transaction(ctx context.Context, streamName string, fn Tran
 	})
 }
 
-func computeMappings(dir string, s storage.Storage) (map[storage.ObjectKey]string, error) {
-	validExts := make([]string, 0, len(storage.ContentTypes))
-	for ext := range storage.ContentTypes {
-		validExts = append(validExts, ext)
+// scopePaths returns the scope paths of the repository relative to rootDir. An empty
+// list means that all of rootDir is in scope. If no part of rootDir is in scope, false
+// is returned.
+func scopePaths(rootDir string, paths []string) ([]string, bool) {
+	if len(paths) == 0 {
+		return nil, true
 	}
 
-	files, err := watcher.WalkDirectoryForFiles(dir, validExts, excludeDirs)
+	rootDir = filepath.Clean(rootDir)
+	scoped := make([]string, 0, len(paths))
+	for _, p := range paths {
+		// If the root directory is inside the scope path, all of it is in scope
+		if isSubPath(p, rootDir) {
+			return nil, true
+		}
+		// If the scope path is inside of the root directory, only consider it
+		if isSubPath(rootDir, p) {
+			rel, _ := filepath.Rel(rootDir, p)
+			scoped = append(scoped, rel)
+		}
+	}
+	return scoped, len(scoped) != 0
+}
+
+// isSubPath returns true if the relative path p is equal to or located inside the relative path dir
+func isSubPath(dir, p string) bool {
+	rel, err := filepath.Rel(dir, p)
+	return err == nil && rel != ".." && !strings.HasPrefix(rel, ".."+string(filepath.Separator))
+}
+
+func computeMappings(fs storage.Filesystem, dir string, opts watcher.Options, s storage.Storage) (map[storage.ObjectKey]string, error) {
+	files, err := walkFiles(fs, dir, opts)
 	if err != nil {
 		return nil, err
 	}
+	return mapFiles(files, func(file string) ([]byte, error) {
+		return storage.ReadFile(fs, file)
+	}, s), nil
+}
+
+// walkFiles returns the files in dir of fs considered with the given options, like
+// watcher.WalkDirectoryForFilesWithOptions does for the OS filesystem
+func walkFiles(fs storage.Filesystem, dir string, opts watcher.Options) ([]string, error) {
+	skipDir := func(subDir string) bool {
+		for _, exclude := range opts.ExcludeDirs {
+			if filepath.Base(subDir) == exclude {
+				return true
+			}
+		}
+		return false
+	}
+
+	var files []string
+	for _, subDir := range watcher.SubDirectories(dir, opts.Paths) {
+		subDirFiles, err := storage.WalkFiles(fs, subDir, skipDir)
+		if err != nil {
+			return nil, err
+		}
+		// Only consider files with valid extensions, and matching the given patterns
+		for _, file := range subDirFiles {
+			if watcher.MatchesOptions(dir, file, opts) {
+				files = append(files, file)
+			}
+		}
+	}
+	return files, nil
+}
 
-	// TODO: Compute the difference between the earlier state, and implement EventStorage so the user
-	// can automatically subscribe to changes of objects between versions.
+// mapFiles decodes the given files, read using readFile, into partial objects, and maps their keys to the files
+func mapFiles(files []string, readFile func(file string) ([]byte, error), s storage.ReadStorage) map[storage.ObjectKey]string {
 	m := map[storage.ObjectKey]string{}
 	for _, file := range files {
-		partObjs, err := storage.DecodePartialObjects(serializer.FromFile(file), s.Serializer().Scheme(), false, nil)
+		content, err := readFile(file)
+		if err != nil {
+			logrus.Errorf("couldn't read %q: %v", file, err)
+			continue
+		}
+		partObjs, err := storage.DecodePartialObjects(serializer.FromBytes(content), s.Serializer().Scheme(), false, nil)
 		if err != nil {
 			logrus.Errorf("couldn't decode %q into a partial object: %v", file, err)
 			continue
@@ -157,5 +639,29 @@ func computeMappings(dir string, s storage.Storage) (map[storage.ObjectKey]strin
 		logrus.Debugf("Adding mapping between %s and %q", key, file)
 		m[key] = file
 	}
-	return m, nil
+	return m
+}
+
+// commitSpecFor converts the result of a transaction into a gitdir.CommitSpec
+func commitSpecFor(result CommitResult) gitdir.CommitSpec {
+	spec := gitdir.CommitSpec{
+		Author: gitdir.Signature{
+			Name:  result.GetAuthorName(),
+			Email: result.GetAuthorEmail(),
+		},
+		Message: result.GetMessage(),
+	}
+	metadata, ok := commitMetadataFor(result)
+	if !ok {
+		return spec
+	}
+	spec.Author.When = metadata.GetAuthorTime()
+	// If no separate committer identity is given, the author is also the committer
+	committer := spec.Author
+	if len(metadata.GetCommitterName()) != 0 {
+		committer.Name, committer.Email = metadata.GetCommitterName(), metadata.GetCommitterEmail()
+	}
+	committer.When = metadata.GetCommitterTime()
+	spec.Committer = &committer
+	return spec
 }
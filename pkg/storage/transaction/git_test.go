@@ -0,0 +1,390 @@
+package transaction
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"reflect"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/weaveworks/libgitops/cmd/sample-app/apis/sample/scheme"
+	"github.com/weaveworks/libgitops/cmd/sample-app/apis/sample/v1alpha1"
+	"github.com/weaveworks/libgitops/pkg/gitdir"
+	"github.com/weaveworks/libgitops/pkg/runtime"
+	"github.com/weaveworks/libgitops/pkg/storage"
+	"github.com/weaveworks/libgitops/pkg/util/watcher"
+)
+
+// newTestRepository creates a bare repository with a commit of the given files on the master branch,
+// and returns its path. The pullrequest/local package can't be used for this, as it imports this package.
+func newTestRepository(t *testing.T, files map[string]string) string {
+	t.Helper()
+	tmpDir, err := ioutil.TempDir("", "libgitops")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { _ = os.RemoveAll(tmpDir) })
+
+	workDir, repoDir := filepath.Join(tmpDir, "work"), filepath.Join(tmpDir, "repo.git")
+	writeTestFiles(t, workDir, files)
+	runGit := func(dir string, args ...string) {
+		t.Helper()
+		cmd := exec.Command("git", append([]string{"-c", "user.name=Test", "-c", "user.email=test@example.com"}, args...)...)
+		cmd.Dir = dir
+		if out, err := cmd.CombinedOutput(); err != nil {
+			t.Fatalf("git %v: %v: %s", args, err, out)
+		}
+	}
+	runGit(workDir, "init", "-q", "-b", "master")
+	runGit(workDir, "add", ".")
+	runGit(workDir, "commit", "-q", "-m", "Initial commit")
+	runGit(tmpDir, "clone", "-q", "--bare", workDir, repoDir)
+	return repoDir
+}
+
+// writeTestFiles writes the given files relative to dir, an empty content removes the file
+func writeTestFiles(t *testing.T, dir string, files map[string]string) {
+	t.Helper()
+	for name, content := range files {
+		file := filepath.Join(dir, filepath.FromSlash(name))
+		if len(content) == 0 {
+			if err := os.Remove(file); err != nil {
+				t.Fatal(err)
+			}
+			continue
+		}
+		if err := os.MkdirAll(filepath.Dir(file), 0755); err != nil {
+			t.Fatal(err)
+		}
+		if err := ioutil.WriteFile(file, []byte(content), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+}
+
+// newTestGitDirectory clones the repository, and cleans it up when the test finishes
+func newTestGitDirectory(t *testing.T, repoDir string) gitdir.GitDirectory {
+	t.Helper()
+	d, err := gitdir.NewGitDirectory(nil, gitdir.GitDirectoryOptions{URL: repoDir, Interval: time.Hour})
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { _ = d.Cleanup() })
+	if err := d.StartCheckoutLoop(); err != nil {
+		t.Fatal(err)
+	}
+	return d
+}
+
+// commitTestFiles commits the given files, see writeTestFiles, to the checked out branch of d,
+// and returns the new commit
+func commitTestFiles(t *testing.T, d gitdir.GitDirectory, files map[string]string) string {
+	t.Helper()
+	writeTestFiles(t, d.Dir(), files)
+	if err := d.Commit(context.Background(), gitdir.CommitSpec{
+		Author:  gitdir.Signature{Name: "Test", Email: "test@example.com"},
+		Message: "Update files",
+	}); err != nil {
+		t.Fatal(err)
+	}
+	commit, err := d.ResolveRevision("HEAD")
+	if err != nil {
+		t.Fatal(err)
+	}
+	return commit
+}
+
+// newTestGitStorage creates a GitStorage for a new clone of the repository
+func newTestGitStorage(t *testing.T, repoDir string, opts GitStorageOptions) *GitStorage {
+	t.Helper()
+	s, err := NewGitStorageWithOptions(newTestGitDirectory(t, repoDir), nil, scheme.Serializer, opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return s.(*GitStorage)
+}
+
+// syncTestGitStorage pulls the latest commit, and waits for the GitStorage to sync it
+func syncTestGitStorage(t *testing.T, s *GitStorage) {
+	t.Helper()
+	if err := s.gitDir.Sync(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	head, err := s.gitDir.ResolveRevision("HEAD")
+	if err != nil {
+		t.Fatal(err)
+	}
+	for deadline := time.Now().Add(5 * time.Second); ; time.Sleep(10 * time.Millisecond) {
+		s.mu.Lock()
+		lastCommit := s.lastCommit
+		s.mu.Unlock()
+		if lastCommit == head {
+			return
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("the GitStorage didn't sync %s, the last synced commit is %s", head, lastCommit)
+		}
+	}
+}
+
+// carYAML returns the manifest of a Car with the given name and brand
+func carYAML(name, brand string) string {
+	return fmt.Sprintf(`apiVersion: sample-app.weave.works/v1alpha1
+kind: Car
+metadata:
+  name: %s
+  namespace: default
+spec:
+  brand: %s
+`, name, brand)
+}
+
+func carKey(name string) storage.ObjectKey {
+	kind := storage.NewKindKey(v1alpha1.SchemeGroupVersion.WithKind("Car"))
+	return storage.NewObjectKey(kind, runtime.NewIdentifier("default/"+name))
+}
+
+// carBrand gets the Car with the given name from s, and returns its brand
+func carBrand(s storage.ReadStorage, name string) (string, error) {
+	obj, err := s.Get(carKey(name))
+	if err != nil {
+		return "", err
+	}
+	car, ok := obj.(*v1alpha1.Car)
+	if !ok {
+		return "", fmt.Errorf("got %T, want a Car", obj)
+	}
+	return car.Spec.Brand, nil
+}
+
+func TestSuspendedReads(t *testing.T) {
+	repoDir := newTestRepository(t, map[string]string{"cars/a.yaml": carYAML("a", "volvo")})
+	s := newTestGitStorage(t, repoDir, GitStorageOptions{})
+	ctx := context.Background()
+
+	// blocked runs fn while the GitDirectory is suspended, and returns true if fn waited for it to resume
+	blocked := func(fn func() error) bool {
+		t.Helper()
+		if err := s.gitDir.Suspend(ctx); err != nil {
+			t.Fatal(err)
+		}
+		done := make(chan error, 1)
+		go func() { done <- fn() }()
+		var waited bool
+		select {
+		case err := <-done:
+			s.gitDir.Resume()
+			if err != nil {
+				t.Fatal(err)
+			}
+			return false
+		case <-time.After(100 * time.Millisecond):
+			waited = true
+		}
+		s.gitDir.Resume()
+		if err := <-done; err != nil {
+			t.Fatal(err)
+		}
+		return waited
+	}
+
+	if !blocked(func() error {
+		_, err := carBrand(s, "a")
+		return err
+	}) {
+		t.Error("Get() didn't wait for the suspended GitDirectory")
+	}
+	if !blocked(func() error {
+		_, err := s.List(carKey("a"))
+		return err
+	}) {
+		t.Error("List() didn't wait for the suspended GitDirectory")
+	}
+	if !blocked(func() error {
+		s.mu.Lock()
+		lastCommit := s.lastCommit
+		s.mu.Unlock()
+		return s.sync(lastCommit)
+	}) {
+		t.Error("sync() didn't wait for the suspended GitDirectory")
+	}
+}
+
+func TestConcurrentUpsertTransactions(t *testing.T) {
+	repoDir := newTestRepository(t, map[string]string{"cars/a.yaml": carYAML("a", "volvo")})
+	s := newTestGitStorage(t, repoDir, GitStorageOptions{})
+	ctx := context.Background()
+
+	// Both transactions open the branch before either of them commits
+	var opened sync.WaitGroup
+	opened.Add(2)
+	errs := make(chan error, 2)
+	for _, name := range []string{"b", "c"} {
+		go func(name string) {
+			_, err := s.UpsertTransaction(ctx, "update-cars", UpsertOptions{}, func(ctx context.Context, tx storage.Storage) (CommitResult, error) {
+				opened.Done()
+				opened.Wait()
+				err := tx.RawStorage().Write(carKey(name), []byte(carYAML(name, "saab")))
+				return &GenericCommitResult{AuthorName: "Test", AuthorEmail: "test@example.com", Title: "Create " + name}, err
+			})
+			errs <- err
+		}(name)
+	}
+
+	// One of the commits is rejected, instead of discarding the other one
+	var failed int
+	for i := 0; i < 2; i++ {
+		if err := <-errs; errors.Is(err, gitdir.ErrBranchChanged) {
+			failed++
+		} else if err != nil {
+			t.Fatal(err)
+		}
+	}
+	if failed != 1 {
+		t.Fatalf("%d transactions failed with ErrBranchChanged, want 1", failed)
+	}
+	branch, err := s.BranchStorage(ctx, "update-cars")
+	if err != nil {
+		t.Fatal(err)
+	}
+	var created int
+	for _, name := range []string{"b", "c"} {
+		if _, err := carBrand(branch, name); err == nil {
+			created++
+		} else if !errors.Is(err, storage.ErrNotTracked) {
+			t.Fatal(err)
+		}
+	}
+	if created != 1 {
+		t.Errorf("%d cars were created on the branch, want 1", created)
+	}
+
+	// The rejected transaction can be retried on top of the committed one
+	_, err = s.UpsertTransaction(ctx, "update-cars", UpsertOptions{}, func(ctx context.Context, tx storage.Storage) (CommitResult, error) {
+		objs, err := tx.List(carKey("a"))
+		if len(objs) != 2 {
+			t.Errorf("got %d cars on the branch, want 2", len(objs))
+		}
+		return &GenericCommitResult{AuthorName: "Test", AuthorEmail: "test@example.com", Title: "Retry"}, err
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestComputeMappings(t *testing.T) {
+	fs := storage.NewMemoryFilesystem()
+	for file, content := range map[string]string{
+		"/repo/cars/a.yaml":        carYAML("a", "volvo"),
+		"/repo/cars/old/b.yaml":    carYAML("b", "saab"),
+		"/repo/cars/c.json":        "not an object",
+		"/repo/cars/.git/d.yaml":   carYAML("d", "audi"),
+		"/repo/trucks/e.yaml":      carYAML("e", "scania"),
+		"/repo/cars/README.md":     "not a manifest",
+		"/repo/cars/nested/f.yaml": carYAML("f", "skoda"),
+	} {
+		if err := storage.WriteFile(fs, file, []byte(content)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	s := newStorage(storage.NewGenericMappedRawStorageWithFilesystem(fs, "/repo", nil), scheme.Serializer)
+	mappings, err := computeMappings(fs, "/repo", watcher.Options{
+		ExcludeDirs:     excludeDirs,
+		ValidExtensions: []string{".yaml", ".json"},
+		Paths:           []string{"cars", "missing"},
+		Exclude:         []string{"old"},
+	}, s)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := map[storage.ObjectKey]string{
+		carKey("a"): "/repo/cars/a.yaml",
+		carKey("f"): "/repo/cars/nested/f.yaml",
+	}
+	if !reflect.DeepEqual(mappings, want) {
+		t.Errorf("computeMappings() = %v, want %v", mappings, want)
+	}
+}
+
+func TestSyncMappings(t *testing.T) {
+	repoDir := newTestRepository(t, map[string]string{
+		"cars/a.yaml": carYAML("a", "volvo"),
+		"cars/c.yaml": carYAML("c", "saab"),
+		"cars/d.yaml": carYAML("d", "audi"),
+		"cars/e.yaml": carYAML("e", "bmw"),
+		"cars/f.yaml": carYAML("f", "skoda"),
+	})
+	s := newTestGitStorage(t, repoDir, GitStorageOptions{RootDir: "cars", Exclude: []string{"old"}})
+	writer := newTestGitDirectory(t, repoDir)
+
+	steps := []struct {
+		name  string
+		files map[string]string
+		want  []string
+	}{
+		{
+			name:  "add",
+			files: map[string]string{"cars/b.yaml": carYAML("b", "opel")},
+			want:  []string{"a", "b", "c", "d", "e", "f"},
+		},
+		{
+			name:  "modify",
+			files: map[string]string{"cars/a.yaml": carYAML("a", "tesla")},
+			want:  []string{"a", "b", "c", "d", "e", "f"},
+		},
+		{
+			name:  "rename",
+			files: map[string]string{"cars/b.yaml": "", "cars/new/b.yaml": carYAML("b", "opel")},
+			want:  []string{"a", "b", "c", "d", "e", "f"},
+		},
+		{
+			name:  "delete",
+			files: map[string]string{"cars/c.yaml": ""},
+			want:  []string{"a", "b", "d", "e", "f"},
+		},
+		{
+			name: "move out of scope",
+			files: map[string]string{
+				"cars/d.yaml":     "",
+				"cars/old/d.yaml": carYAML("d", "audi"), // excluded
+				"cars/e.yaml":     "",
+				"trucks/e.yaml":   carYAML("e", "bmw"), // outside of RootDir
+			},
+			want: []string{"a", "b", "f"},
+		},
+		{
+			name:  "stop decoding",
+			files: map[string]string{"cars/f.yaml": "not an object"},
+			want:  []string{"a", "b"},
+		},
+	}
+	for _, step := range steps {
+		commitTestFiles(t, writer, step.files)
+		syncTestGitStorage(t, s)
+
+		s.mu.Lock()
+		mappings := s.mappings
+		s.mu.Unlock()
+		full, err := computeMappings(s.fs, s.rootDir, s.walkOpts, s.s)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !reflect.DeepEqual(mappings, full) {
+			t.Errorf("%s: synced mappings %v, want the full mappings %v", step.name, mappings, full)
+		}
+		if len(mappings) != len(step.want) {
+			t.Errorf("%s: mapped %d objects, want %d", step.name, len(mappings), len(step.want))
+		}
+		for _, name := range step.want {
+			if _, ok := mappings[carKey(name)]; !ok {
+				t.Errorf("%s: %q isn't mapped", step.name, name)
+			}
+		}
+	}
+}
@@ -74,8 +74,9 @@ type PullRequestSpec interface {
 	// GetMergeBranch returns the branch that is pending to be merged into main with this PR.
 	// +required
 	GetMergeBranch() string
-	// GetMergeBranch returns the branch that is pending to be merged into main with this PR.
-	// +required
+	// GetRepositoryRef returns the repository the PR is created in. It's nil for repositories that
+	// aren't hosted by a Git forge, e.g. local ones. Providers for Git forges return ErrNoRepositoryRef then.
+	// +optional
 	GetRepositoryRef() gitprovider.RepositoryRef
 }
 
@@ -90,8 +91,8 @@ type GenericPullRequestSpec struct {
 	// MergeBranch returns the branch that is pending to be merged into main with this PR.
 	// +required
 	MergeBranch string
-	// RepositoryRef returns the branch that is pending to be merged into main with this PR.
-	// +required
+	// RepositoryRef returns the repository the PR is created in, if hosted by a Git forge.
+	// +optional
 	RepositoryRef gitprovider.RepositoryRef
 }
 
@@ -115,16 +116,85 @@ func (r *GenericPullRequestSpec) Validate() error {
 	if len(r.MergeBranch) == 0 {
 		v.Required("MergeBranch")
 	}
-	if r.RepositoryRef == nil {
-		v.Required("RepositoryRef")
-	}
 	return v.Error()
 }
 
+// PullRequestState describes whether a Pull Request is open, merged or closed.
+type PullRequestState string
+
+const (
+	// PullRequestOpen means that the Pull Request can still be merged.
+	PullRequestOpen = PullRequestState("open")
+	// PullRequestMerged means that the Pull Request has been merged.
+	PullRequestMerged = PullRequestState("merged")
+	// PullRequestClosed means that the Pull Request was closed without being merged.
+	PullRequestClosed = PullRequestState("closed")
+)
+
+// CheckState is the combined state of the CI checks for the head commit of a Pull Request.
+type CheckState string
+
+const (
+	// CheckStateNone means that no checks have been reported for the head commit.
+	CheckStateNone = CheckState("")
+	// CheckStatePending means that at least one check is still running, and none have failed.
+	CheckStatePending = CheckState("pending")
+	// CheckStateSuccess means that all checks have passed.
+	CheckStateSuccess = CheckState("success")
+	// CheckStateFailure means that at least one check has failed.
+	CheckStateFailure = CheckState("failure")
+)
+
+// MergeMethod describes how a Pull Request is merged.
+type MergeMethod string
+
+const (
+	// MergeMethodMerge creates a merge commit.
+	MergeMethodMerge = MergeMethod("merge")
+	// MergeMethodSquash squashes the commits of the Pull Request into one.
+	MergeMethodSquash = MergeMethod("squash")
+	// MergeMethodRebase rebases the commits of the Pull Request onto the main branch.
+	MergeMethodRebase = MergeMethod("rebase")
+)
+
+// PullRequest is a handle to a Pull Request, returned by the PullRequestProvider.
+type PullRequest struct {
+	// RepositoryRef is the repository the Pull Request belongs to.
+	RepositoryRef gitprovider.RepositoryRef
+	// Number identifies the Pull Request in the repository, e.g. 42 for GitHub PR #42 or GitLab MR !42.
+	Number int
+	// URL is the web URL of the Pull Request.
+	URL string
+	// MergeBranch is the branch that is pending to be merged with this Pull Request.
+	MergeBranch string
+	// HeadSHA is the latest commit of MergeBranch, as seen by the provider.
+	HeadSHA string
+	// State tells whether the Pull Request is open, merged or closed.
+	State PullRequestState
+	// Checks is the combined state of the CI checks for HeadSHA.
+	Checks CheckState
+}
+
 // PullRequestProvider is an interface for providers that can create so-called "Pull Requests",
 // as popularized by Git. A Pull Request is a formal ask for a branch to be merged into the main one.
 // It can be UI-based, as in GitHub and GitLab, or it can be using some other method.
 type PullRequestProvider interface {
 	// CreatePullRequest creates a Pull Request using the given specification.
-	CreatePullRequest(ctx context.Context, spec PullRequestSpec) error
+	CreatePullRequest(ctx context.Context, spec PullRequestSpec) (*PullRequest, error)
+	// GetPullRequest returns the current status of the Pull Request with the given number.
+	GetPullRequest(ctx context.Context, ref gitprovider.RepositoryRef, number int) (*PullRequest, error)
+	// FindPullRequest returns the open Pull Request for the given merge branch.
+	// If there is none, ErrPullRequestNotFound is returned.
+	FindPullRequest(ctx context.Context, ref gitprovider.RepositoryRef, mergeBranch string) (*PullRequest, error)
+	// UpdatePullRequest sets the title, description, labels and assignees of the Pull Request
+	// to those of result. The milestone is only changed if result specifies one.
+	UpdatePullRequest(ctx context.Context, pr *PullRequest, result PullRequestResult) (*PullRequest, error)
+	// MergePullRequest merges the Pull Request using the given method. The merge fails if the
+	// merge branch has moved on from pr.HeadSHA, so that only the checked state gets merged.
+	// ErrMergeMethodNotSupported is returned if the provider doesn't support the merge method.
+	MergePullRequest(ctx context.Context, pr *PullRequest, method MergeMethod) error
+	// ClosePullRequest closes the Pull Request without merging it.
+	ClosePullRequest(ctx context.Context, pr *PullRequest) error
+	// CommentPullRequest adds a comment with the given body to the Pull Request.
+	CommentPullRequest(ctx context.Context, pr *PullRequest, body string) error
 }
@@ -11,7 +11,11 @@ import (
 	"github.com/weaveworks/libgitops/pkg/storage/transaction"
 )
 
-// TODO: This package should really only depend on go-git-providers' abstraction interface
+// TODO: This package should really only depend on go-git-providers' abstraction interface.
+// As of go-git-providers v0.0.2, the gitprovider interfaces only cover organizations, repositories,
+// teams and deploy keys, and there is no way to create pull requests through them. Hence the raw
+// go-github client is used here. Once gitprovider exposes pull requests, this package (and the
+// GitLab one) should be replaced by a single provider-agnostic implementation.
 
 var ErrProviderNotSupported = errors.New("only the Github go-git-providers provider is supported at the moment")
 
@@ -28,14 +32,17 @@ type prCreator struct {
 	c gitprovider.Client
 }
 
-func (c *prCreator) CreatePullRequest(ctx context.Context, spec transaction.PullRequestSpec) error {
+func (c *prCreator) CreatePullRequest(ctx context.Context, spec transaction.PullRequestSpec) (*transaction.PullRequest, error) {
 	// First, validate the input
 	if err := spec.Validate(); err != nil {
-		return fmt.Errorf("given PullRequestSpec wasn't valid")
+		return nil, fmt.Errorf("given PullRequestSpec wasn't valid")
+	}
+	if spec.GetRepositoryRef() == nil {
+		return nil, transaction.ErrNoRepositoryRef
 	}
 
 	// Use the "raw" go-github client to do this
-	ghClient := c.c.Raw().(*gogithub.Client)
+	ghClient := c.client()
 
 	// Helper variables
 	owner := spec.GetRepositoryRef().GetIdentity()
@@ -53,14 +60,128 @@ func (c *prCreator) CreatePullRequest(ctx context.Context, spec transaction.Pull
 		Body:  body,
 	})
 	if err != nil {
-		return err
+		return nil, err
+	}
+
+	// Set the milestone, assignees and labels, which are properties of the underlying issue
+	if err := editIssue(ctx, ghClient, owner, repo, pr.GetNumber(), spec, false); err != nil {
+		return nil, err
+	}
+	return c.toPullRequest(ctx, spec.GetRepositoryRef(), pr)
+}
+
+func (c *prCreator) GetPullRequest(ctx context.Context, ref gitprovider.RepositoryRef, number int) (*transaction.PullRequest, error) {
+	if ref == nil {
+		return nil, transaction.ErrNoRepositoryRef
+	}
+	pr, _, err := c.client().PullRequests.Get(ctx, ref.GetIdentity(), ref.GetRepository(), number)
+	if err != nil {
+		return nil, err
+	}
+	return c.toPullRequest(ctx, ref, pr)
+}
+
+func (c *prCreator) FindPullRequest(ctx context.Context, ref gitprovider.RepositoryRef, mergeBranch string) (*transaction.PullRequest, error) {
+	if ref == nil {
+		return nil, transaction.ErrNoRepositoryRef
+	}
+	// The head branch filter needs to be prefixed with the owner of the branch
+	prs, _, err := c.client().PullRequests.List(ctx, ref.GetIdentity(), ref.GetRepository(), &gogithub.PullRequestListOptions{
+		State: "open",
+		Head:  fmt.Sprintf("%s:%s", ref.GetIdentity(), mergeBranch),
+	})
+	if err != nil {
+		return nil, err
+	}
+	if len(prs) == 0 {
+		return nil, fmt.Errorf("%w: for branch %q", transaction.ErrPullRequestNotFound, mergeBranch)
+	}
+	return c.toPullRequest(ctx, ref, prs[0])
+}
+
+func (c *prCreator) UpdatePullRequest(ctx context.Context, pr *transaction.PullRequest, result transaction.PullRequestResult) (*transaction.PullRequest, error) {
+	// First, validate the input
+	if err := result.Validate(); err != nil {
+		return nil, fmt.Errorf("given PullRequestResult wasn't valid")
+	}
+
+	ghClient := c.client()
+	owner, repo := pr.RepositoryRef.GetIdentity(), pr.RepositoryRef.GetRepository()
+
+	updated, _, err := ghClient.PullRequests.Edit(ctx, owner, repo, pr.Number, &gogithub.PullRequest{
+		Title: gogithub.String(result.GetTitle()),
+		Body:  gogithub.String(result.GetDescription()),
+	})
+	if err != nil {
+		return nil, err
+	}
+	if err := editIssue(ctx, ghClient, owner, repo, pr.Number, result, true); err != nil {
+		return nil, err
+	}
+	return c.toPullRequest(ctx, pr.RepositoryRef, updated)
+}
+
+func (c *prCreator) MergePullRequest(ctx context.Context, pr *transaction.PullRequest, method transaction.MergeMethod) error {
+	_, _, err := c.client().PullRequests.Merge(ctx, pr.RepositoryRef.GetIdentity(), pr.RepositoryRef.GetRepository(), pr.Number, "", &gogithub.PullRequestOptions{
+		SHA:         pr.HeadSHA,
+		MergeMethod: string(method),
+	})
+	return err
+}
+
+func (c *prCreator) ClosePullRequest(ctx context.Context, pr *transaction.PullRequest) error {
+	_, _, err := c.client().PullRequests.Edit(ctx, pr.RepositoryRef.GetIdentity(), pr.RepositoryRef.GetRepository(), pr.Number, &gogithub.PullRequest{
+		State: gogithub.String("closed"),
+	})
+	return err
+}
+
+func (c *prCreator) CommentPullRequest(ctx context.Context, pr *transaction.PullRequest, body string) error {
+	// Pull Request comments are issue comments, review comments are bound to lines in the diff
+	_, _, err := c.client().Issues.CreateComment(ctx, pr.RepositoryRef.GetIdentity(), pr.RepositoryRef.GetRepository(), pr.Number, &gogithub.IssueComment{
+		Body: gogithub.String(body),
+	})
+	return err
+}
+
+func (c *prCreator) client() *gogithub.Client {
+	return c.c.Raw().(*gogithub.Client)
+}
+
+// toPullRequest converts a go-github Pull Request into a handle, including the state of its checks
+func (c *prCreator) toPullRequest(ctx context.Context, ref gitprovider.RepositoryRef, pr *gogithub.PullRequest) (*transaction.PullRequest, error) {
+	state := transaction.PullRequestOpen
+	if pr.GetMerged() {
+		state = transaction.PullRequestMerged
+	} else if pr.GetState() == "closed" {
+		state = transaction.PullRequestClosed
+	}
+
+	checks, err := getCheckState(ctx, c.client(), ref.GetIdentity(), ref.GetRepository(), pr.GetHead().GetSHA())
+	if err != nil {
+		return nil, err
 	}
 
-	// If spec.GetMilestone() is set, fetch the ID of the milestone
+	return &transaction.PullRequest{
+		RepositoryRef: ref,
+		Number:        pr.GetNumber(),
+		URL:           pr.GetHTMLURL(),
+		MergeBranch:   pr.GetHead().GetRef(),
+		HeadSHA:       pr.GetHead().GetSHA(),
+		State:         state,
+		Checks:        checks,
+	}, nil
+}
+
+// editIssue sets the milestone, assignees and labels of the issue behind a Pull Request. If replace
+// is true, labels and assignees not in result are removed, otherwise only specified fields are set.
+func editIssue(ctx context.Context, c *gogithub.Client, owner, repo string, number int, result transaction.PullRequestResult, replace bool) error {
+	// If result.GetMilestone() is set, fetch the ID of the milestone
 	// Only set milestoneID to non-nil if specified
 	var milestoneID *int
-	if len(spec.GetMilestone()) != 0 {
-		milestoneID, err = getMilestoneID(ctx, ghClient, owner, repo, spec.GetMilestone())
+	if len(result.GetMilestone()) != 0 {
+		var err error
+		milestoneID, err = getMilestoneID(ctx, c, owner, repo, result.GetMilestone())
 		if err != nil {
 			return err
 		}
@@ -68,19 +189,21 @@ func (c *prCreator) CreatePullRequest(ctx context.Context, spec transaction.Pull
 
 	// Only set assignees to non-nil if specified
 	var assignees *[]string
-	if a := spec.GetAssignees(); len(a) != 0 {
+	if a := result.GetAssignees(); len(a) != 0 || replace {
+		a = append([]string{}, a...)
 		assignees = &a
 	}
 
 	// Only set labels to non-nil if specified
 	var labels *[]string
-	if l := spec.GetLabels(); len(l) != 0 {
+	if l := result.GetLabels(); len(l) != 0 || replace {
+		l = append([]string{}, l...)
 		labels = &l
 	}
 
 	// Only PATCH the PR if any of the fields were set
 	if milestoneID != nil || assignees != nil || labels != nil {
-		_, _, err := ghClient.Issues.Edit(ctx, owner, repo, pr.GetNumber(), &gogithub.IssueRequest{
+		_, _, err := c.Issues.Edit(ctx, owner, repo, number, &gogithub.IssueRequest{
 			Milestone: milestoneID,
 			Assignees: assignees,
 			Labels:    labels,
@@ -89,10 +212,69 @@ func (c *prCreator) CreatePullRequest(ctx context.Context, spec transaction.Pull
 			return err
 		}
 	}
-
 	return nil
 }
 
+// getCheckState combines the commit statuses and check runs of the given commit
+func getCheckState(ctx context.Context, c *gogithub.Client, owner, repo, sha string) (transaction.CheckState, error) {
+	var states []string
+
+	// The combined state is "pending" if there are no statuses, so only use it if there are any
+	status, _, err := c.Repositories.GetCombinedStatus(ctx, owner, repo, sha, nil)
+	if err != nil {
+		return transaction.CheckStateNone, err
+	}
+	if status.GetTotalCount() != 0 {
+		states = append(states, status.GetState())
+	}
+
+	// Go through all pages of check runs, as any of them might have failed
+	opts := &gogithub.ListCheckRunsOptions{ListOptions: gogithub.ListOptions{PerPage: 100}}
+	for {
+		runs, resp, err := c.Checks.ListCheckRunsForRef(ctx, owner, repo, sha, opts)
+		if err != nil {
+			return transaction.CheckStateNone, err
+		}
+		for _, run := range runs.CheckRuns {
+			if run.GetStatus() != "completed" {
+				states = append(states, "pending")
+				continue
+			}
+			switch run.GetConclusion() {
+			case "success", "neutral", "skipped":
+				states = append(states, "success")
+			default:
+				states = append(states, "failure")
+			}
+		}
+		if resp.NextPage == 0 {
+			break
+		}
+		opts.Page = resp.NextPage
+	}
+
+	return combineCheckStates(states), nil
+}
+
+// combineCheckStates returns failure if any state is a failure, otherwise pending if any state
+// is pending, otherwise success. If there are no states, CheckStateNone is returned.
+func combineCheckStates(states []string) transaction.CheckState {
+	if len(states) == 0 {
+		return transaction.CheckStateNone
+	}
+	combined := transaction.CheckStateSuccess
+	for _, state := range states {
+		switch state {
+		case "success":
+		case "pending":
+			combined = transaction.CheckStatePending
+		default:
+			return transaction.CheckStateFailure
+		}
+	}
+	return combined
+}
+
 func getMilestoneID(ctx context.Context, c *gogithub.Client, owner, repo, milestoneName string) (*int, error) {
 	// List all milestones in the repo
 	// TODO: This could/should use pagination
@@ -0,0 +1,262 @@
+package github
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"reflect"
+	"testing"
+
+	"github.com/fluxcd/go-git-providers/github"
+	"github.com/fluxcd/go-git-providers/gitprovider"
+	gogithub "github.com/google/go-github/v32/github"
+	"github.com/weaveworks/libgitops/pkg/storage/transaction"
+)
+
+// fakeClient is a gitprovider.Client returning a go-github client for the given server
+type fakeClient struct {
+	gitprovider.Client
+	raw *gogithub.Client
+}
+
+func (c *fakeClient) ProviderID() gitprovider.ProviderID { return github.ProviderID }
+func (c *fakeClient) Raw() interface{}                   { return c.raw }
+
+func newTestProvider(t *testing.T, mux *http.ServeMux) transaction.PullRequestProvider {
+	t.Helper()
+	srv := httptest.NewServer(mux)
+	t.Cleanup(srv.Close)
+
+	raw := gogithub.NewClient(nil)
+	baseURL, err := url.Parse(srv.URL + "/")
+	if err != nil {
+		t.Fatal(err)
+	}
+	raw.BaseURL = baseURL
+	p, err := NewGitHubPRProvider(&fakeClient{raw: raw})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return p
+}
+
+var testRepoRef = &gitprovider.OrgRepositoryRef{
+	OrganizationRef: gitprovider.OrganizationRef{Domain: "github.com", Organization: "weaveworks"},
+	RepositoryName:  "libgitops",
+}
+
+// handleChecks serves the commit statuses and check runs of the given commit. The check runs are split
+// into pages of one run each.
+func handleChecks(mux *http.ServeMux, sha string, statuses []string, runs []*gogithub.CheckRun) {
+	mux.HandleFunc("/repos/weaveworks/libgitops/commits/"+sha+"/status", func(w http.ResponseWriter, r *http.Request) {
+		combined := &gogithub.CombinedStatus{State: gogithub.String("pending"), TotalCount: gogithub.Int(len(statuses))}
+		for _, state := range statuses {
+			// The combined state is computed like by GitHub
+			if state == "failure" || (state == "pending" && combined.GetState() != "failure") || (state == "success" && combined.GetTotalCount() == 1) {
+				combined.State = gogithub.String(state)
+			}
+		}
+		_ = json.NewEncoder(w).Encode(combined)
+	})
+	mux.HandleFunc("/repos/weaveworks/libgitops/commits/"+sha+"/check-runs", func(w http.ResponseWriter, r *http.Request) {
+		page := 1
+		_, _ = fmt.Sscan(r.URL.Query().Get("page"), &page)
+		result := &gogithub.ListCheckRunsResults{Total: gogithub.Int(len(runs))}
+		if page <= len(runs) {
+			result.CheckRuns = runs[page-1 : page]
+		}
+		if page < len(runs) {
+			w.Header().Set("Link", fmt.Sprintf(`<%s?page=%d>; rel="next"`, r.URL.Path, page+1))
+		}
+		_ = json.NewEncoder(w).Encode(result)
+	})
+}
+
+func checkRun(status, conclusion string) *gogithub.CheckRun {
+	run := &gogithub.CheckRun{Status: gogithub.String(status)}
+	if len(conclusion) != 0 {
+		run.Conclusion = gogithub.String(conclusion)
+	}
+	return run
+}
+
+func TestGetPullRequest(t *testing.T) {
+	tests := []struct {
+		name       string
+		pr         *gogithub.PullRequest
+		statuses   []string
+		runs       []*gogithub.CheckRun
+		wantState  transaction.PullRequestState
+		wantChecks transaction.CheckState
+	}{
+		{
+			name:       "open without checks",
+			pr:         &gogithub.PullRequest{State: gogithub.String("open")},
+			wantState:  transaction.PullRequestOpen,
+			wantChecks: transaction.CheckStateNone,
+		},
+		{
+			name:       "closed with passed checks",
+			pr:         &gogithub.PullRequest{State: gogithub.String("closed")},
+			statuses:   []string{"success"},
+			runs:       []*gogithub.CheckRun{checkRun("completed", "success"), checkRun("completed", "skipped")},
+			wantState:  transaction.PullRequestClosed,
+			wantChecks: transaction.CheckStateSuccess,
+		},
+		{
+			name:       "merged with running checks",
+			pr:         &gogithub.PullRequest{State: gogithub.String("closed"), Merged: gogithub.Bool(true)},
+			statuses:   []string{"success"},
+			runs:       []*gogithub.CheckRun{checkRun("completed", "neutral"), checkRun("in_progress", "")},
+			wantState:  transaction.PullRequestMerged,
+			wantChecks: transaction.CheckStatePending,
+		},
+		{
+			name:       "failed check run on the last page",
+			pr:         &gogithub.PullRequest{State: gogithub.String("open")},
+			runs:       []*gogithub.CheckRun{checkRun("completed", "success"), checkRun("in_progress", ""), checkRun("completed", "failure")},
+			wantState:  transaction.PullRequestOpen,
+			wantChecks: transaction.CheckStateFailure,
+		},
+		{
+			name:       "failed status",
+			pr:         &gogithub.PullRequest{State: gogithub.String("open")},
+			statuses:   []string{"failure"},
+			runs:       []*gogithub.CheckRun{checkRun("completed", "success")},
+			wantState:  transaction.PullRequestOpen,
+			wantChecks: transaction.CheckStateFailure,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			tt.pr.Number = gogithub.Int(1)
+			tt.pr.HTMLURL = gogithub.String("https://github.com/weaveworks/libgitops/pull/1")
+			tt.pr.Head = &gogithub.PullRequestBranch{Ref: gogithub.String("update-foo"), SHA: gogithub.String("abc")}
+
+			mux := http.NewServeMux()
+			mux.HandleFunc("/repos/weaveworks/libgitops/pulls/1", func(w http.ResponseWriter, r *http.Request) {
+				_ = json.NewEncoder(w).Encode(tt.pr)
+			})
+			handleChecks(mux, "abc", tt.statuses, tt.runs)
+
+			pr, err := newTestProvider(t, mux).GetPullRequest(context.Background(), testRepoRef, 1)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if pr.State != tt.wantState || pr.Checks != tt.wantChecks {
+				t.Errorf("got state %q with checks %q, want %q with %q", pr.State, pr.Checks, tt.wantState, tt.wantChecks)
+			}
+			if pr.Number != 1 || pr.MergeBranch != "update-foo" || pr.HeadSHA != "abc" || pr.URL != tt.pr.GetHTMLURL() {
+				t.Errorf("got %+v, want the fields of the Pull Request", pr)
+			}
+		})
+	}
+}
+
+func TestEditIssue(t *testing.T) {
+	newResult := func(labels, assignees []string) transaction.PullRequestResult {
+		return &transaction.GenericPullRequestResult{
+			CommitResult: &transaction.GenericCommitResult{
+				AuthorName:  "Bot",
+				AuthorEmail: "bot@example.com",
+				Title:       "Update foo",
+			},
+			Labels:    labels,
+			Assignees: assignees,
+		}
+	}
+
+	tests := []struct {
+		name    string
+		update  bool
+		result  transaction.PullRequestResult
+		want    *gogithub.IssueRequest
+		wantSet bool
+	}{
+		{
+			name:    "create sets given fields",
+			result:  newResult([]string{"bug"}, nil),
+			want:    &gogithub.IssueRequest{Labels: &[]string{"bug"}},
+			wantSet: true,
+		},
+		{
+			name:   "create without fields doesn't edit",
+			result: newResult(nil, nil),
+		},
+		{
+			name:    "update replaces all fields",
+			update:  true,
+			result:  newResult(nil, []string{"alice"}),
+			want:    &gogithub.IssueRequest{Labels: &[]string{}, Assignees: &[]string{"alice"}},
+			wantSet: true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			pr := &gogithub.PullRequest{
+				Number: gogithub.Int(1),
+				State:  gogithub.String("open"),
+				Head:   &gogithub.PullRequestBranch{Ref: gogithub.String("update-foo"), SHA: gogithub.String("abc")},
+			}
+			var got *gogithub.IssueRequest
+			mux := http.NewServeMux()
+			mux.HandleFunc("/repos/weaveworks/libgitops/pulls", func(w http.ResponseWriter, r *http.Request) {
+				w.WriteHeader(http.StatusCreated)
+				_ = json.NewEncoder(w).Encode(pr)
+			})
+			mux.HandleFunc("/repos/weaveworks/libgitops/pulls/1", func(w http.ResponseWriter, r *http.Request) {
+				_ = json.NewEncoder(w).Encode(pr)
+			})
+			mux.HandleFunc("/repos/weaveworks/libgitops/issues/1", func(w http.ResponseWriter, r *http.Request) {
+				got = &gogithub.IssueRequest{}
+				_ = json.NewDecoder(r.Body).Decode(got)
+				_ = json.NewEncoder(w).Encode(&gogithub.Issue{Number: gogithub.Int(1)})
+			})
+			handleChecks(mux, "abc", nil, nil)
+			p := newTestProvider(t, mux)
+
+			ctx := context.Background()
+			var err error
+			if tt.update {
+				_, err = p.UpdatePullRequest(ctx, &transaction.PullRequest{RepositoryRef: testRepoRef, Number: 1}, tt.result)
+			} else {
+				_, err = p.CreatePullRequest(ctx, &transaction.GenericPullRequestSpec{
+					PullRequestResult: tt.result,
+					MainBranch:        "master",
+					MergeBranch:       "update-foo",
+					RepositoryRef:     testRepoRef,
+				})
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if (got != nil) != tt.wantSet {
+				t.Fatalf("issue edited = %t, want %t", got != nil, tt.wantSet)
+			}
+			if tt.wantSet && !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("got issue request %+v, want %+v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestCombineCheckStates(t *testing.T) {
+	tests := []struct {
+		states []string
+		want   transaction.CheckState
+	}{
+		{nil, transaction.CheckStateNone},
+		{[]string{"success", "success"}, transaction.CheckStateSuccess},
+		{[]string{"success", "pending"}, transaction.CheckStatePending},
+		{[]string{"pending", "failure", "success"}, transaction.CheckStateFailure},
+		{[]string{"error"}, transaction.CheckStateFailure},
+	}
+	for _, tt := range tests {
+		if got := combineCheckStates(tt.states); got != tt.want {
+			t.Errorf("combineCheckStates(%v) = %q, want %q", tt.states, got, tt.want)
+		}
+	}
+}
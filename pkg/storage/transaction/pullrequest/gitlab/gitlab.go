@@ -0,0 +1,324 @@
+package gitlab
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"strings"
+
+	"github.com/fluxcd/go-git-providers/gitprovider"
+	log "github.com/sirupsen/logrus"
+	"github.com/weaveworks/libgitops/pkg/storage/transaction"
+)
+
+// The go-git-providers GitLab provider isn't implemented yet, hence the GitLab REST API (v4)
+// is used directly.
+
+var ErrNoToken = errors.New("a GitLab access token is required")
+
+// Options are optional settings for the GitLab provider.
+type Options struct {
+	// BaseURL is the URL of the GitLab instance, e.g. "https://gitlab.example.com".
+	// Default: "https://" followed by the domain of the repository the merge request is created in
+	BaseURL string
+	// HTTPClient is used for the API requests.
+	// Default: http.DefaultClient
+	HTTPClient *http.Client
+}
+
+func (o *Options) Default() {
+	if o.HTTPClient == nil {
+		o.HTTPClient = http.DefaultClient
+	}
+}
+
+// NewGitLabPRProvider returns a new transaction.PullRequestProvider creating GitLab merge requests,
+// authenticating using the given personal or project access token with the "api" scope.
+func NewGitLabPRProvider(token string, opts Options) (transaction.PullRequestProvider, error) {
+	if len(token) == 0 {
+		return nil, ErrNoToken
+	}
+	opts.Default()
+	return &mrCreator{token, opts}, nil
+}
+
+type mrCreator struct {
+	token string
+	opts  Options
+}
+
+// mergeRequestRequest is the request body for creating or updating a merge request
+type mergeRequestRequest struct {
+	SourceBranch string  `json:"source_branch,omitempty"`
+	TargetBranch string  `json:"target_branch,omitempty"`
+	Title        string  `json:"title,omitempty"`
+	Description  *string `json:"description,omitempty"`
+	Labels       *string `json:"labels,omitempty"`
+	AssigneeIDs  *[]int  `json:"assignee_ids,omitempty"`
+	MilestoneID  *int    `json:"milestone_id,omitempty"`
+	StateEvent   string  `json:"state_event,omitempty"`
+}
+
+// apiObject holds the fields used from the API responses
+type apiObject struct {
+	ID    int    `json:"id"`
+	Title string `json:"title"`
+}
+
+// mergeRequest holds the fields used from merge request API responses
+type mergeRequest struct {
+	IID          int    `json:"iid"`
+	WebURL       string `json:"web_url"`
+	SHA          string `json:"sha"`
+	State        string `json:"state"`
+	SourceBranch string `json:"source_branch"`
+	HeadPipeline *struct {
+		Status string `json:"status"`
+	} `json:"head_pipeline"`
+}
+
+func (c *mrCreator) CreatePullRequest(ctx context.Context, spec transaction.PullRequestSpec) (*transaction.PullRequest, error) {
+	// First, validate the input
+	if err := spec.Validate(); err != nil {
+		return nil, fmt.Errorf("given PullRequestSpec wasn't valid: %w", err)
+	}
+	if spec.GetRepositoryRef() == nil {
+		return nil, transaction.ErrNoRepositoryRef
+	}
+
+	ref := spec.GetRepositoryRef()
+	mr, err := c.newRequest(ctx, ref, spec, false)
+	if err != nil {
+		return nil, err
+	}
+	mr.SourceBranch = spec.GetMergeBranch()
+	mr.TargetBranch = spec.GetMainBranch()
+
+	// Create the Merge Request, GitLab allows setting all fields at once
+	var created mergeRequest
+	if err := c.do(ctx, http.MethodPost, c.projectAPI(ref)+"/merge_requests", mr, &created); err != nil {
+		return nil, err
+	}
+	log.Infof("Created merge request !%d: %s", created.IID, created.WebURL)
+	return toPullRequest(ref, &created), nil
+}
+
+func (c *mrCreator) GetPullRequest(ctx context.Context, ref gitprovider.RepositoryRef, number int) (*transaction.PullRequest, error) {
+	if ref == nil {
+		return nil, transaction.ErrNoRepositoryRef
+	}
+	var mr mergeRequest
+	if err := c.do(ctx, http.MethodGet, fmt.Sprintf("%s/merge_requests/%d", c.projectAPI(ref), number), nil, &mr); err != nil {
+		return nil, err
+	}
+	return toPullRequest(ref, &mr), nil
+}
+
+func (c *mrCreator) FindPullRequest(ctx context.Context, ref gitprovider.RepositoryRef, mergeBranch string) (*transaction.PullRequest, error) {
+	if ref == nil {
+		return nil, transaction.ErrNoRepositoryRef
+	}
+	var mrs []mergeRequest
+	u := fmt.Sprintf("%s/merge_requests?state=opened&source_branch=%s", c.projectAPI(ref), url.QueryEscape(mergeBranch))
+	if err := c.do(ctx, http.MethodGet, u, nil, &mrs); err != nil {
+		return nil, err
+	}
+	if len(mrs) == 0 {
+		return nil, fmt.Errorf("%w: for branch %q", transaction.ErrPullRequestNotFound, mergeBranch)
+	}
+	// The list doesn't include the pipeline status, so get the merge request itself
+	return c.GetPullRequest(ctx, ref, mrs[0].IID)
+}
+
+func (c *mrCreator) UpdatePullRequest(ctx context.Context, pr *transaction.PullRequest, result transaction.PullRequestResult) (*transaction.PullRequest, error) {
+	// First, validate the input
+	if err := result.Validate(); err != nil {
+		return nil, fmt.Errorf("given PullRequestResult wasn't valid: %w", err)
+	}
+
+	mr, err := c.newRequest(ctx, pr.RepositoryRef, result, true)
+	if err != nil {
+		return nil, err
+	}
+	var updated mergeRequest
+	if err := c.do(ctx, http.MethodPut, c.mergeRequestAPI(pr), mr, &updated); err != nil {
+		return nil, err
+	}
+	return toPullRequest(pr.RepositoryRef, &updated), nil
+}
+
+func (c *mrCreator) MergePullRequest(ctx context.Context, pr *transaction.PullRequest, method transaction.MergeMethod) error {
+	// Whether merge commits are created or the branch is rebased is a project setting in GitLab
+	var squash bool
+	switch method {
+	case transaction.MergeMethodMerge:
+	case transaction.MergeMethodSquash:
+		squash = true
+	default:
+		return fmt.Errorf("%w: %q", transaction.ErrMergeMethodNotSupported, method)
+	}
+
+	body := map[string]interface{}{"squash": squash, "sha": pr.HeadSHA}
+	return c.do(ctx, http.MethodPut, c.mergeRequestAPI(pr)+"/merge", body, &mergeRequest{})
+}
+
+func (c *mrCreator) ClosePullRequest(ctx context.Context, pr *transaction.PullRequest) error {
+	return c.do(ctx, http.MethodPut, c.mergeRequestAPI(pr), &mergeRequestRequest{StateEvent: "close"}, &mergeRequest{})
+}
+
+func (c *mrCreator) CommentPullRequest(ctx context.Context, pr *transaction.PullRequest, body string) error {
+	return c.do(ctx, http.MethodPost, c.mergeRequestAPI(pr)+"/notes", map[string]string{"body": body}, &apiObject{})
+}
+
+// newRequest creates the request body for the merge request fields in result. If replace is
+// true, the description, labels and assignees are set even if empty, to remove the current ones.
+func (c *mrCreator) newRequest(ctx context.Context, ref gitprovider.RepositoryRef, result transaction.PullRequestResult, replace bool) (*mergeRequestRequest, error) {
+	mr := &mergeRequestRequest{Title: result.GetTitle()}
+	if d := result.GetDescription(); len(d) != 0 || replace {
+		mr.Description = &d
+	}
+	if l := strings.Join(result.GetLabels(), ","); len(l) != 0 || replace {
+		mr.Labels = &l
+	}
+
+	// If result.GetMilestone() is set, fetch the ID of the milestone
+	if len(result.GetMilestone()) != 0 {
+		milestoneID, err := c.getMilestoneID(ctx, c.projectAPI(ref), result.GetMilestone())
+		if err != nil {
+			return nil, err
+		}
+		mr.MilestoneID = &milestoneID
+	}
+
+	// Assignees are given as user IDs, look them up from the usernames
+	assigneeIDs := []int{}
+	for _, username := range result.GetAssignees() {
+		userID, err := c.getUserID(ctx, c.apiURL(ref), username)
+		if err != nil {
+			return nil, err
+		}
+		assigneeIDs = append(assigneeIDs, userID)
+	}
+	if len(assigneeIDs) != 0 || replace {
+		mr.AssigneeIDs = &assigneeIDs
+	}
+	return mr, nil
+}
+
+// apiURL returns the base URL of the API for the GitLab instance of the repository
+func (c *mrCreator) apiURL(ref gitprovider.RepositoryRef) string {
+	baseURL := c.opts.BaseURL
+	if len(baseURL) == 0 {
+		baseURL = "https://" + ref.GetDomain()
+	}
+	return strings.TrimSuffix(baseURL, "/") + "/api/v4"
+}
+
+// projectAPI returns the API URL of the repository. The project is identified by its URL-encoded
+// full path, including any subgroups.
+func (c *mrCreator) projectAPI(ref gitprovider.RepositoryRef) string {
+	return c.apiURL(ref) + "/projects/" + url.PathEscape(ref.GetIdentity()+"/"+ref.GetRepository())
+}
+
+func (c *mrCreator) mergeRequestAPI(pr *transaction.PullRequest) string {
+	return fmt.Sprintf("%s/merge_requests/%d", c.projectAPI(pr.RepositoryRef), pr.Number)
+}
+
+func toPullRequest(ref gitprovider.RepositoryRef, mr *mergeRequest) *transaction.PullRequest {
+	state := transaction.PullRequestOpen
+	switch mr.State {
+	case "merged":
+		state = transaction.PullRequestMerged
+	case "closed", "locked":
+		state = transaction.PullRequestClosed
+	}
+
+	checks := transaction.CheckStateNone
+	if mr.HeadPipeline != nil {
+		switch mr.HeadPipeline.Status {
+		case "success", "skipped":
+			checks = transaction.CheckStateSuccess
+		case "failed", "canceled":
+			checks = transaction.CheckStateFailure
+		default:
+			checks = transaction.CheckStatePending
+		}
+	}
+
+	return &transaction.PullRequest{
+		RepositoryRef: ref,
+		Number:        mr.IID,
+		URL:           mr.WebURL,
+		MergeBranch:   mr.SourceBranch,
+		HeadSHA:       mr.SHA,
+		State:         state,
+		Checks:        checks,
+	}
+}
+
+func (c *mrCreator) getMilestoneID(ctx context.Context, projectAPI, milestoneName string) (int, error) {
+	var milestones []apiObject
+	u := fmt.Sprintf("%s/milestones?title=%s", projectAPI, url.QueryEscape(milestoneName))
+	if err := c.do(ctx, http.MethodGet, u, nil, &milestones); err != nil {
+		return 0, err
+	}
+	for _, milestone := range milestones {
+		if milestone.Title == milestoneName {
+			return milestone.ID, nil
+		}
+	}
+	return 0, fmt.Errorf("couldn't find milestone with name: %s", milestoneName)
+}
+
+func (c *mrCreator) getUserID(ctx context.Context, api, username string) (int, error) {
+	var users []apiObject
+	if err := c.do(ctx, http.MethodGet, api+"/users?username="+url.QueryEscape(username), nil, &users); err != nil {
+		return 0, err
+	}
+	if len(users) == 0 {
+		return 0, fmt.Errorf("couldn't find user with username: %s", username)
+	}
+	return users[0].ID, nil
+}
+
+// do performs an authenticated API request with in as the JSON body, if set, and decodes the
+// JSON response into out
+func (c *mrCreator) do(ctx context.Context, method, u string, in, out interface{}) error {
+	var body io.Reader
+	if in != nil {
+		content, err := json.Marshal(in)
+		if err != nil {
+			return err
+		}
+		body = bytes.NewReader(content)
+	}
+	req, err := http.NewRequestWithContext(ctx, method, u, body)
+	if err != nil {
+		return err
+	}
+	req.Header.Set("PRIVATE-TOKEN", c.token)
+	if in != nil {
+		req.Header.Set("Content-Type", "application/json")
+	}
+
+	resp, err := c.opts.HTTPClient.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	content, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return err
+	}
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		return fmt.Errorf("GitLab API request %s %s failed with %s: %s", method, req.URL.Path, resp.Status, content)
+	}
+	return json.Unmarshal(content, out)
+}
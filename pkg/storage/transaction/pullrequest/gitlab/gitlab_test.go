@@ -0,0 +1,171 @@
+package gitlab
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"testing"
+
+	"github.com/fluxcd/go-git-providers/gitprovider"
+	"github.com/weaveworks/libgitops/pkg/storage/transaction"
+)
+
+func TestCreatePullRequest(t *testing.T) {
+	var got mergeRequestRequest
+	mux := http.NewServeMux()
+	mux.HandleFunc("/api/v4/users", func(w http.ResponseWriter, r *http.Request) {
+		ids := map[string]int{"alice": 1, "bob": 2}
+		id, ok := ids[r.URL.Query().Get("username")]
+		if !ok {
+			_, _ = w.Write([]byte(`[]`))
+			return
+		}
+		_ = json.NewEncoder(w).Encode([]apiObject{{ID: id}})
+	})
+	// The project path is URL-encoded, including the subgroup
+	mux.HandleFunc("/api/v4/projects/group/sub/repo/milestones", func(w http.ResponseWriter, r *http.Request) {
+		_ = json.NewEncoder(w).Encode([]apiObject{{ID: 42, Title: r.URL.Query().Get("title")}})
+	})
+	mux.HandleFunc("/api/v4/projects/group/sub/repo/merge_requests", func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.RawPath != "/api/v4/projects/group%2Fsub%2Frepo/merge_requests" {
+			http.Error(w, "project path not encoded", http.StatusNotFound)
+			return
+		}
+		if r.Method != http.MethodPost {
+			http.Error(w, "unexpected method", http.StatusMethodNotAllowed)
+			return
+		}
+		_ = json.NewDecoder(r.Body).Decode(&got)
+		w.WriteHeader(http.StatusCreated)
+		_, _ = w.Write([]byte(`{"iid": 1, "web_url": "https://gitlab.example.com/mr/1", "sha": "abc", "state": "opened", "source_branch": "update-foo"}`))
+	})
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("PRIVATE-TOKEN") != "token" {
+			http.Error(w, "unauthorized", http.StatusUnauthorized)
+			return
+		}
+		mux.ServeHTTP(w, r)
+	}))
+	defer srv.Close()
+
+	p, err := NewGitLabPRProvider("token", Options{BaseURL: srv.URL})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	newSpec := func(assignees ...string) transaction.PullRequestSpec {
+		return &transaction.GenericPullRequestSpec{
+			PullRequestResult: &transaction.GenericPullRequestResult{
+				CommitResult: &transaction.GenericCommitResult{
+					AuthorName:  "Bot",
+					AuthorEmail: "bot@example.com",
+					Title:       "Update foo",
+					Description: "Bumps the replicas.",
+				},
+				Labels:    []string{"bot", "sync"},
+				Assignees: assignees,
+				Milestone: "v1.0",
+			},
+			MainBranch:  "master",
+			MergeBranch: "update-foo",
+			RepositoryRef: gitprovider.OrgRepositoryRef{
+				OrganizationRef: gitprovider.OrganizationRef{
+					Domain:           "gitlab.example.com",
+					Organization:     "group",
+					SubOrganizations: []string{"sub"},
+				},
+				RepositoryName: "repo",
+			},
+		}
+	}
+
+	pr, err := p.CreatePullRequest(context.Background(), newSpec("alice", "bob"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	description, labels, assigneeIDs, milestoneID := "Bumps the replicas.", "bot,sync", []int{1, 2}, 42
+	want := mergeRequestRequest{
+		SourceBranch: "update-foo",
+		TargetBranch: "master",
+		Title:        "Update foo",
+		Description:  &description,
+		Labels:       &labels,
+		AssigneeIDs:  &assigneeIDs,
+		MilestoneID:  &milestoneID,
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("CreatePullRequest() sent %+v, want %+v", got, want)
+	}
+	if pr.Number != 1 || pr.HeadSHA != "abc" || pr.State != transaction.PullRequestOpen || pr.Checks != transaction.CheckStateNone {
+		t.Errorf("CreatePullRequest() = %+v, want an open merge request !1 at abc without checks", pr)
+	}
+
+	if _, err := p.CreatePullRequest(context.Background(), newSpec("mallory")); err == nil {
+		t.Error("CreatePullRequest() with unknown assignee succeeded, want error")
+	}
+}
+
+func TestPullRequestLifecycle(t *testing.T) {
+	var merged, closed bool
+	mux := http.NewServeMux()
+	mux.HandleFunc("/api/v4/projects/group/repo/merge_requests", func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Query().Get("source_branch") != "update-foo" {
+			_, _ = w.Write([]byte(`[]`))
+			return
+		}
+		_, _ = w.Write([]byte(`[{"iid": 7}]`))
+	})
+	mux.HandleFunc("/api/v4/projects/group/repo/merge_requests/7", func(w http.ResponseWriter, r *http.Request) {
+		if r.Method == http.MethodPut {
+			var req mergeRequestRequest
+			_ = json.NewDecoder(r.Body).Decode(&req)
+			closed = req.StateEvent == "close"
+		}
+		_, _ = w.Write([]byte(`{"iid": 7, "sha": "abc", "state": "opened", "source_branch": "update-foo", "head_pipeline": {"status": "success"}}`))
+	})
+	mux.HandleFunc("/api/v4/projects/group/repo/merge_requests/7/merge", func(w http.ResponseWriter, r *http.Request) {
+		var req struct {
+			SHA    string `json:"sha"`
+			Squash bool   `json:"squash"`
+		}
+		_ = json.NewDecoder(r.Body).Decode(&req)
+		merged = req.SHA == "abc" && req.Squash
+		_, _ = w.Write([]byte(`{"iid": 7, "state": "merged"}`))
+	})
+	srv := httptest.NewServer(mux)
+	defer srv.Close()
+
+	p, err := NewGitLabPRProvider("token", Options{BaseURL: srv.URL})
+	if err != nil {
+		t.Fatal(err)
+	}
+	ref := gitprovider.UserRepositoryRef{
+		UserRef:        gitprovider.UserRef{Domain: "gitlab.example.com", UserLogin: "group"},
+		RepositoryName: "repo",
+	}
+	ctx := context.Background()
+
+	if _, err := p.FindPullRequest(ctx, ref, "other"); !errors.Is(err, transaction.ErrPullRequestNotFound) {
+		t.Errorf("FindPullRequest() error = %v, want ErrPullRequestNotFound", err)
+	}
+	pr, err := p.FindPullRequest(ctx, ref, "update-foo")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if pr.Number != 7 || pr.Checks != transaction.CheckStateSuccess {
+		t.Errorf("FindPullRequest() = %+v, want !7 with successful checks", pr)
+	}
+
+	if err := p.MergePullRequest(ctx, pr, transaction.MergeMethodRebase); !errors.Is(err, transaction.ErrMergeMethodNotSupported) {
+		t.Errorf("MergePullRequest() error = %v, want ErrMergeMethodNotSupported", err)
+	}
+	if err := p.MergePullRequest(ctx, pr, transaction.MergeMethodSquash); err != nil || !merged {
+		t.Errorf("MergePullRequest() error = %v, merged = %t", err, merged)
+	}
+	if err := p.ClosePullRequest(ctx, pr); err != nil || !closed {
+		t.Errorf("ClosePullRequest() error = %v, closed = %t", err, closed)
+	}
+}
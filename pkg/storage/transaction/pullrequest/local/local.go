@@ -0,0 +1,507 @@
+package local
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/fluxcd/go-git-providers/gitprovider"
+	git "github.com/go-git/go-git/v5"
+	"github.com/go-git/go-git/v5/plumbing"
+	"github.com/go-git/go-git/v5/plumbing/object"
+	log "github.com/sirupsen/logrus"
+	"github.com/weaveworks/libgitops/pkg/storage/transaction"
+)
+
+var (
+	// ErrMergeConflict happens if a file was changed both on the main branch and in the Pull Request.
+	ErrMergeConflict = errors.New("the pull request conflicts with the main branch")
+	// ErrHeadChanged happens if the merge branch has moved on from the PullRequest.HeadSHA given to merge.
+	ErrHeadChanged = errors.New("the merge branch has changed since")
+	// ErrNotOpen happens when trying to modify a Pull Request that has been merged or closed.
+	ErrNotOpen = errors.New("the pull request isn't open")
+)
+
+// Options are optional settings for the local provider.
+type Options struct {
+	// Dir is an optional directory to store the Pull Requests in, as one JSON file each.
+	// Existing Pull Requests in it are loaded when the provider is created. If unset, the
+	// Pull Requests are only kept in memory. The last used Pull Request number is stored
+	// next to them, so that numbers aren't reused after Pull Requests are removed.
+	Dir string
+}
+
+// NewLocalPRProvider returns a new transaction.PullRequestProvider for the (bare) Git repository
+// in repoDir, e.g. created by InitBareRepository. Instead of using a Git forge, Pull Requests are
+// recorded locally, and merged by committing directly to the main branch of the repository.
+// The RepositoryRef of the Pull Requests isn't used, as the provider is bound to repoDir.
+func NewLocalPRProvider(repoDir string, opts Options) (transaction.PullRequestProvider, error) {
+	repo, err := git.PlainOpen(repoDir)
+	if err != nil {
+		return nil, fmt.Errorf("git open %q error: %v", repoDir, err)
+	}
+
+	p := &prProvider{
+		repo:  repo,
+		opts:  opts,
+		pulls: make(map[int]*pullRequest),
+	}
+	if len(opts.Dir) != 0 {
+		if err := p.load(); err != nil {
+			return nil, err
+		}
+	}
+	return p, nil
+}
+
+type prProvider struct {
+	repo  *git.Repository
+	opts  Options
+	pulls map[int]*pullRequest
+	// lastNumber is the number of the last created Pull Request, it never decreases
+	lastNumber int
+	lock       sync.Mutex
+}
+
+// pullRequest is the record of a Pull Request, as stored in Options.Dir
+type pullRequest struct {
+	Number      int                          `json:"number"`
+	Title       string                       `json:"title"`
+	Description string                       `json:"description,omitempty"`
+	Labels      []string                     `json:"labels,omitempty"`
+	Assignees   []string                     `json:"assignees,omitempty"`
+	Milestone   string                       `json:"milestone,omitempty"`
+	AuthorName  string                       `json:"authorName"`
+	AuthorEmail string                       `json:"authorEmail"`
+	MainBranch  string                       `json:"mainBranch"`
+	MergeBranch string                       `json:"mergeBranch"`
+	State       transaction.PullRequestState `json:"state"`
+	// HeadSHA is recorded when the Pull Request is merged or closed, as the branch may be removed afterwards
+	HeadSHA  string    `json:"headSHA,omitempty"`
+	Comments []string  `json:"comments,omitempty"`
+	Created  time.Time `json:"created"`
+}
+
+func (p *prProvider) CreatePullRequest(_ context.Context, spec transaction.PullRequestSpec) (*transaction.PullRequest, error) {
+	// First, validate the input
+	if err := spec.Validate(); err != nil {
+		return nil, fmt.Errorf("given PullRequestSpec wasn't valid: %w", err)
+	}
+
+	p.lock.Lock()
+	defer p.lock.Unlock()
+
+	// Make sure both branches exist
+	for _, branch := range []string{spec.GetMainBranch(), spec.GetMergeBranch()} {
+		if _, err := p.branchHead(branch); err != nil {
+			return nil, err
+		}
+	}
+
+	number, err := p.nextNumber()
+	if err != nil {
+		return nil, err
+	}
+	pr := &pullRequest{
+		Number:      number,
+		AuthorName:  spec.GetAuthorName(),
+		AuthorEmail: spec.GetAuthorEmail(),
+		MainBranch:  spec.GetMainBranch(),
+		MergeBranch: spec.GetMergeBranch(),
+		State:       transaction.PullRequestOpen,
+		Created:     time.Now().UTC(),
+	}
+	pr.setResult(spec)
+	if err := p.save(pr); err != nil {
+		return nil, err
+	}
+	log.Infof("Created local pull request #%d for branch %q", pr.Number, pr.MergeBranch)
+	return p.toPullRequest(spec.GetRepositoryRef(), pr), nil
+}
+
+func (p *prProvider) GetPullRequest(_ context.Context, ref gitprovider.RepositoryRef, number int) (*transaction.PullRequest, error) {
+	p.lock.Lock()
+	defer p.lock.Unlock()
+
+	pr, err := p.get(number)
+	if err != nil {
+		return nil, err
+	}
+	return p.toPullRequest(ref, pr), nil
+}
+
+func (p *prProvider) FindPullRequest(_ context.Context, ref gitprovider.RepositoryRef, mergeBranch string) (*transaction.PullRequest, error) {
+	p.lock.Lock()
+	defer p.lock.Unlock()
+
+	for _, pr := range p.pulls {
+		if pr.MergeBranch == mergeBranch && pr.State == transaction.PullRequestOpen {
+			return p.toPullRequest(ref, pr), nil
+		}
+	}
+	return nil, fmt.Errorf("%w: for branch %q", transaction.ErrPullRequestNotFound, mergeBranch)
+}
+
+func (p *prProvider) UpdatePullRequest(_ context.Context, handle *transaction.PullRequest, result transaction.PullRequestResult) (*transaction.PullRequest, error) {
+	// First, validate the input
+	if err := result.Validate(); err != nil {
+		return nil, fmt.Errorf("given PullRequestResult wasn't valid: %w", err)
+	}
+
+	p.lock.Lock()
+	defer p.lock.Unlock()
+
+	pr, err := p.getOpen(handle.Number)
+	if err != nil {
+		return nil, err
+	}
+	// Keep the milestone if none is given
+	milestone := pr.Milestone
+	pr.setResult(result)
+	if len(pr.Milestone) == 0 {
+		pr.Milestone = milestone
+	}
+	if err := p.save(pr); err != nil {
+		return nil, err
+	}
+	return p.toPullRequest(handle.RepositoryRef, pr), nil
+}
+
+func (p *prProvider) MergePullRequest(_ context.Context, handle *transaction.PullRequest, method transaction.MergeMethod) error {
+	p.lock.Lock()
+	defer p.lock.Unlock()
+
+	pr, err := p.getOpen(handle.Number)
+	if err != nil {
+		return err
+	}
+	head, err := p.branchHead(pr.MergeBranch)
+	if err != nil {
+		return err
+	}
+	// Only merge the state that was seen by the caller
+	if len(handle.HeadSHA) != 0 && handle.HeadSHA != head.String() {
+		return fmt.Errorf("%w: expected %s, got %s", ErrHeadChanged, handle.HeadSHA, head)
+	}
+
+	if err := p.merge(pr, head, method); err != nil {
+		return err
+	}
+	pr.State = transaction.PullRequestMerged
+	pr.HeadSHA = head.String()
+	if err := p.save(pr); err != nil {
+		return err
+	}
+	log.Infof("Merged local pull request #%d into branch %q", pr.Number, pr.MainBranch)
+	return nil
+}
+
+func (p *prProvider) ClosePullRequest(_ context.Context, handle *transaction.PullRequest) error {
+	p.lock.Lock()
+	defer p.lock.Unlock()
+
+	pr, err := p.getOpen(handle.Number)
+	if err != nil {
+		return err
+	}
+	if head, err := p.branchHead(pr.MergeBranch); err == nil {
+		pr.HeadSHA = head.String()
+	}
+	pr.State = transaction.PullRequestClosed
+	return p.save(pr)
+}
+
+func (p *prProvider) CommentPullRequest(_ context.Context, handle *transaction.PullRequest, body string) error {
+	p.lock.Lock()
+	defer p.lock.Unlock()
+
+	pr, err := p.get(handle.Number)
+	if err != nil {
+		return err
+	}
+	pr.Comments = append(pr.Comments, body)
+	return p.save(pr)
+}
+
+// merge commits the changes of the merge branch since it diverged from the main branch to the
+// main branch, as a merge commit or a squashed commit. For rebases, only fast-forwards are supported.
+func (p *prProvider) merge(pr *pullRequest, head plumbing.Hash, method transaction.MergeMethod) error {
+	main, err := p.branchHead(pr.MainBranch)
+	if err != nil {
+		return err
+	}
+	mainCommit, err := p.repo.CommitObject(main)
+	if err != nil {
+		return err
+	}
+	headCommit, err := p.repo.CommitObject(head)
+	if err != nil {
+		return err
+	}
+
+	bases, err := mainCommit.MergeBase(headCommit)
+	if err != nil {
+		return fmt.Errorf("git merge-base error: %v", err)
+	}
+	if len(bases) == 0 {
+		return fmt.Errorf("%w: the branches have no common history", ErrMergeConflict)
+	}
+	base := bases[0]
+	fastForward := base.Hash == main
+
+	parents := []plumbing.Hash{main}
+	switch method {
+	case transaction.MergeMethodMerge:
+		parents = append(parents, head)
+	case transaction.MergeMethodSquash:
+	case transaction.MergeMethodRebase:
+		if !fastForward {
+			return fmt.Errorf("%w: only fast-forward rebases are supported", transaction.ErrMergeMethodNotSupported)
+		}
+		mainRef := plumbing.NewBranchReferenceName(pr.MainBranch)
+		return p.repo.Storer.SetReference(plumbing.NewHashReference(mainRef, head))
+	default:
+		return fmt.Errorf("%w: %q", transaction.ErrMergeMethodNotSupported, method)
+	}
+
+	// Apply the changes of the merge branch on top of the main branch
+	headChanges, err := diff(base, headCommit)
+	if err != nil {
+		return err
+	}
+	if !fastForward {
+		mainChanges, err := diff(base, mainCommit)
+		if err != nil {
+			return err
+		}
+		if conflicts := conflictingPaths(mainChanges, headChanges); len(conflicts) != 0 {
+			return fmt.Errorf("%w: %s", ErrMergeConflict, strings.Join(conflicts, ", "))
+		}
+	}
+
+	wt, err := openWorktree(p.repo)
+	if err != nil {
+		return err
+	}
+	if err := wt.Checkout(&git.CheckoutOptions{Branch: plumbing.NewBranchReferenceName(pr.MainBranch), Force: true}); err != nil {
+		return fmt.Errorf("git checkout error: %v", err)
+	}
+	headTree, err := headCommit.Tree()
+	if err != nil {
+		return err
+	}
+	for _, change := range headChanges {
+		if err := applyChange(wt, headTree, change); err != nil {
+			return err
+		}
+	}
+
+	_, err = wt.Commit(fmt.Sprintf("Merge pull request #%d from %s\n\n%s", pr.Number, pr.MergeBranch, pr.Title), &git.CommitOptions{
+		Author: &object.Signature{
+			Name:  pr.AuthorName,
+			Email: pr.AuthorEmail,
+			When:  time.Now(),
+		},
+		Parents: parents,
+	})
+	if err != nil {
+		return fmt.Errorf("git commit error: %v", err)
+	}
+	return nil
+}
+
+// diff returns the changes between the trees of the given commits
+func diff(from, to *object.Commit) (object.Changes, error) {
+	fromTree, err := from.Tree()
+	if err != nil {
+		return nil, err
+	}
+	toTree, err := to.Tree()
+	if err != nil {
+		return nil, err
+	}
+	changes, err := object.DiffTree(fromTree, toTree)
+	if err != nil {
+		return nil, fmt.Errorf("git diff error: %v", err)
+	}
+	return changes, nil
+}
+
+// conflictingPaths returns the paths changed by both sets of changes, in order
+func conflictingPaths(a, b object.Changes) []string {
+	changed := make(map[string]bool)
+	for _, change := range a {
+		changed[change.From.Name] = true
+		changed[change.To.Name] = true
+	}
+	var conflicts []string
+	for _, change := range b {
+		for _, name := range []string{change.From.Name, change.To.Name} {
+			if len(name) != 0 && changed[name] {
+				conflicts = append(conflicts, name)
+			}
+		}
+	}
+	return conflicts
+}
+
+// applyChange removes the old file of the change from the worktree, and writes the new file from tree
+func applyChange(wt *git.Worktree, tree *object.Tree, change *object.Change) error {
+	if len(change.From.Name) != 0 && change.From.Name != change.To.Name {
+		if _, err := wt.Remove(change.From.Name); err != nil {
+			return fmt.Errorf("git rm error: %v", err)
+		}
+	}
+	if len(change.To.Name) == 0 {
+		return nil
+	}
+	f, err := tree.File(change.To.Name)
+	if err != nil {
+		return err
+	}
+	content, err := f.Contents()
+	if err != nil {
+		return err
+	}
+	return writeFile(wt, change.To.Name, []byte(content))
+}
+
+func (p *prProvider) branchHead(branch string) (plumbing.Hash, error) {
+	ref, err := p.repo.Reference(plumbing.NewBranchReferenceName(branch), true)
+	if err != nil {
+		return plumbing.ZeroHash, fmt.Errorf("git get branch %q error: %w", branch, err)
+	}
+	return ref.Hash(), nil
+}
+
+func (p *prProvider) toPullRequest(ref gitprovider.RepositoryRef, pr *pullRequest) *transaction.PullRequest {
+	headSHA := pr.HeadSHA
+	if pr.State == transaction.PullRequestOpen {
+		if head, err := p.branchHead(pr.MergeBranch); err == nil {
+			headSHA = head.String()
+		}
+	}
+	var url string
+	if len(p.opts.Dir) != 0 {
+		url = "file://" + p.path(pr.Number)
+	}
+
+	return &transaction.PullRequest{
+		RepositoryRef: ref,
+		Number:        pr.Number,
+		URL:           url,
+		MergeBranch:   pr.MergeBranch,
+		HeadSHA:       headSHA,
+		State:         pr.State,
+		// There's no CI running for local Pull Requests
+		Checks: transaction.CheckStateNone,
+	}
+}
+
+func (p *prProvider) get(number int) (*pullRequest, error) {
+	pr, ok := p.pulls[number]
+	if !ok {
+		return nil, fmt.Errorf("%w: #%d", transaction.ErrPullRequestNotFound, number)
+	}
+	return pr, nil
+}
+
+func (p *prProvider) getOpen(number int) (*pullRequest, error) {
+	pr, err := p.get(number)
+	if err != nil {
+		return nil, err
+	}
+	if pr.State != transaction.PullRequestOpen {
+		return nil, fmt.Errorf("%w: #%d is %s", ErrNotOpen, number, pr.State)
+	}
+	return pr, nil
+}
+
+func (pr *pullRequest) setResult(result transaction.PullRequestResult) {
+	pr.Title = result.GetTitle()
+	pr.Description = result.GetDescription()
+	pr.Labels = result.GetLabels()
+	pr.Assignees = result.GetAssignees()
+	pr.Milestone = result.GetMilestone()
+}
+
+// save stores the Pull Request, and writes it to Options.Dir, if set
+func (p *prProvider) save(pr *pullRequest) error {
+	p.pulls[pr.Number] = pr
+	if len(p.opts.Dir) == 0 {
+		return nil
+	}
+
+	if err := os.MkdirAll(p.opts.Dir, 0755); err != nil {
+		return err
+	}
+	content, err := json.MarshalIndent(pr, "", "  ")
+	if err != nil {
+		return err
+	}
+	return ioutil.WriteFile(p.path(pr.Number), content, 0644)
+}
+
+// nextNumber increments the last used Pull Request number, and writes it to Options.Dir, if set
+func (p *prProvider) nextNumber() (int, error) {
+	number := p.lastNumber + 1
+	if len(p.opts.Dir) != 0 {
+		if err := os.MkdirAll(p.opts.Dir, 0755); err != nil {
+			return 0, err
+		}
+		if err := ioutil.WriteFile(p.lastNumberPath(), []byte(strconv.Itoa(number)), 0644); err != nil {
+			return 0, err
+		}
+	}
+	p.lastNumber = number
+	return number, nil
+}
+
+// load reads the Pull Requests and the last used number stored in Options.Dir, if it exists
+func (p *prProvider) load() error {
+	content, err := ioutil.ReadFile(p.lastNumberPath())
+	if err == nil {
+		if p.lastNumber, err = strconv.Atoi(strings.TrimSpace(string(content))); err != nil {
+			return fmt.Errorf("failed to read the last pull request number: %v", err)
+		}
+	} else if !os.IsNotExist(err) {
+		return err
+	}
+
+	files, err := filepath.Glob(filepath.Join(p.opts.Dir, "*.json"))
+	if err != nil {
+		return err
+	}
+	for _, file := range files {
+		content, err := ioutil.ReadFile(file)
+		if err != nil {
+			return err
+		}
+		pr := &pullRequest{}
+		if err := json.Unmarshal(content, pr); err != nil {
+			return fmt.Errorf("failed to read pull request %q: %v", file, err)
+		}
+		p.pulls[pr.Number] = pr
+		// Also skip the numbers of Pull Requests stored without updating the last number
+		if pr.Number > p.lastNumber {
+			p.lastNumber = pr.Number
+		}
+	}
+	return nil
+}
+
+func (p *prProvider) path(number int) string {
+	return filepath.Join(p.opts.Dir, fmt.Sprintf("%d.json", number))
+}
+
+func (p *prProvider) lastNumberPath() string {
+	return filepath.Join(p.opts.Dir, "last-number")
+}
@@ -0,0 +1,238 @@
+package local
+
+import (
+	"context"
+	"errors"
+	"io/ioutil"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/fluxcd/go-git-providers/gitprovider"
+	git "github.com/go-git/go-git/v5"
+	"github.com/go-git/go-git/v5/plumbing"
+	"github.com/go-git/go-git/v5/plumbing/object"
+	"github.com/weaveworks/libgitops/pkg/storage/transaction"
+)
+
+// commitTo commits the given files on top of branch from, and points branch to to the new commit
+func commitTo(t *testing.T, repo *git.Repository, from, to string, files map[string]string) {
+	t.Helper()
+	wt, err := openWorktree(repo)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// Check out the commit, so that committing doesn't move the from branch
+	ref, err := repo.Reference(plumbing.NewBranchReferenceName(from), true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := wt.Checkout(&git.CheckoutOptions{Hash: ref.Hash(), Force: true}); err != nil {
+		t.Fatal(err)
+	}
+	for file, content := range files {
+		if err := writeFile(wt, file, []byte(content)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	hash, err := wt.Commit("Update", &git.CommitOptions{
+		Author: &object.Signature{Name: "Test", Email: "test@example.com", When: time.Now()},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := repo.Storer.SetReference(plumbing.NewHashReference(plumbing.NewBranchReferenceName(to), hash)); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func readFile(t *testing.T, repo *git.Repository, branch, file string) string {
+	t.Helper()
+	ref, err := repo.Reference(plumbing.NewBranchReferenceName(branch), true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	commit, err := repo.CommitObject(ref.Hash())
+	if err != nil {
+		t.Fatal(err)
+	}
+	f, err := commit.File(file)
+	if err != nil {
+		t.Fatal(err)
+	}
+	content, err := f.Contents()
+	if err != nil {
+		t.Fatal(err)
+	}
+	return content
+}
+
+func newSpec(branch string) transaction.PullRequestSpec {
+	return &transaction.GenericPullRequestSpec{
+		PullRequestResult: &transaction.GenericPullRequestResult{
+			CommitResult: &transaction.GenericCommitResult{
+				AuthorName:  "Test",
+				AuthorEmail: "test@example.com",
+				Title:       "Update " + branch,
+			},
+		},
+		MainBranch:  "master",
+		MergeBranch: branch,
+		// The repository ref isn't used by the local provider, but is required
+		RepositoryRef: gitprovider.UserRepositoryRef{
+			UserRef:        gitprovider.UserRef{Domain: "localhost", UserLogin: "test"},
+			RepositoryName: "repo",
+		},
+	}
+}
+
+func TestMergePullRequest(t *testing.T) {
+	tmpDir, err := ioutil.TempDir("", "libgitops")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(tmpDir)
+
+	repoDir, prDir := tmpDir+"/repo.git", tmpDir+"/pulls"
+	if err := InitBareRepository(repoDir, "master", map[string][]byte{
+		"a.yaml":     []byte("a: 1"),
+		"dir/b.yaml": []byte("b: 1"),
+	}); err != nil {
+		t.Fatal(err)
+	}
+	repo, err := git.PlainOpen(repoDir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	p, err := NewLocalPRProvider(repoDir, Options{Dir: prDir})
+	if err != nil {
+		t.Fatal(err)
+	}
+	ctx := context.Background()
+
+	// A fast-forward merge
+	commitTo(t, repo, "master", "update-a", map[string]string{"a.yaml": "a: 2"})
+	pr, err := p.CreatePullRequest(ctx, newSpec("update-a"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := p.MergePullRequest(ctx, pr, transaction.MergeMethodMerge); err != nil {
+		t.Fatal(err)
+	}
+	if got := readFile(t, repo, "master", "a.yaml"); got != "a: 2" {
+		t.Errorf("a.yaml = %q after merge, want %q", got, "a: 2")
+	}
+	if err := p.MergePullRequest(ctx, pr, transaction.MergeMethodMerge); !errors.Is(err, ErrNotOpen) {
+		t.Errorf("MergePullRequest() of a merged PR error = %v, want ErrNotOpen", err)
+	}
+
+	// Two branches diverging from the same main branch commit
+	commitTo(t, repo, "master", "update-b", map[string]string{"dir/b.yaml": "b: 2"})
+	commitTo(t, repo, "master", "conflict-a", map[string]string{"a.yaml": "a: 3"})
+	commitTo(t, repo, "master", "master", map[string]string{"a.yaml": "a: 4"})
+
+	prB, err := p.CreatePullRequest(ctx, newSpec("update-b"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := p.FindPullRequest(ctx, nil, "update-b"); err != nil {
+		t.Errorf("FindPullRequest() error = %v", err)
+	}
+	if err := p.MergePullRequest(ctx, prB, transaction.MergeMethodRebase); !errors.Is(err, transaction.ErrMergeMethodNotSupported) {
+		t.Errorf("MergePullRequest() with a non-fast-forward rebase error = %v, want ErrMergeMethodNotSupported", err)
+	}
+	if err := p.MergePullRequest(ctx, prB, transaction.MergeMethodSquash); err != nil {
+		t.Fatal(err)
+	}
+	if got := readFile(t, repo, "master", "dir/b.yaml"); got != "b: 2" {
+		t.Errorf("dir/b.yaml = %q after merge, want %q", got, "b: 2")
+	}
+	if got := readFile(t, repo, "master", "a.yaml"); got != "a: 4" {
+		t.Errorf("a.yaml = %q after merge, want %q", got, "a: 4")
+	}
+
+	prConflict, err := p.CreatePullRequest(ctx, newSpec("conflict-a"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := p.MergePullRequest(ctx, prConflict, transaction.MergeMethodMerge); !errors.Is(err, ErrMergeConflict) {
+		t.Errorf("MergePullRequest() with a conflict error = %v, want ErrMergeConflict", err)
+	}
+
+	// Merging must not add an index to the bare repository
+	if _, err := os.Stat(repoDir + "/index"); !os.IsNotExist(err) {
+		t.Errorf("expected no index in the bare repository, got error %v", err)
+	}
+
+	// The Pull Requests are loaded from the directory
+	p, err = NewLocalPRProvider(repoDir, Options{Dir: prDir})
+	if err != nil {
+		t.Fatal(err)
+	}
+	got, err := p.GetPullRequest(ctx, nil, prB.Number)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got.State != transaction.PullRequestMerged {
+		t.Errorf("GetPullRequest() state = %q, want %q", got.State, transaction.PullRequestMerged)
+	}
+}
+
+func TestPullRequestNumbers(t *testing.T) {
+	tmpDir, err := ioutil.TempDir("", "libgitops")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(tmpDir)
+
+	repoDir, prDir := tmpDir+"/repo.git", tmpDir+"/pulls"
+	if err := InitBareRepository(repoDir, "master", map[string][]byte{"a.yaml": []byte("a: 1")}); err != nil {
+		t.Fatal(err)
+	}
+	repo, err := git.PlainOpen(repoDir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	commitTo(t, repo, "master", "update-a", map[string]string{"a.yaml": "a: 2"})
+	ctx := context.Background()
+	create := func(p transaction.PullRequestProvider, want int) {
+		t.Helper()
+		pr, err := p.CreatePullRequest(ctx, newSpec("update-a"))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if pr.Number != want {
+			t.Errorf("CreatePullRequest() number = %d, want %d", pr.Number, want)
+		}
+	}
+
+	p, err := NewLocalPRProvider(repoDir, Options{Dir: prDir})
+	if err != nil {
+		t.Fatal(err)
+	}
+	create(p, 1)
+	create(p, 2)
+	create(p, 3)
+
+	// Numbers aren't reused after the last Pull Request is removed
+	if err := os.Remove(prDir + "/3.json"); err != nil {
+		t.Fatal(err)
+	}
+	p, err = NewLocalPRProvider(repoDir, Options{Dir: prDir})
+	if err != nil {
+		t.Fatal(err)
+	}
+	create(p, 4)
+
+	// Nor after the last number is lost, when there are gaps
+	for _, file := range []string{"/2.json", "/last-number"} {
+		if err := os.Remove(prDir + file); err != nil {
+			t.Fatal(err)
+		}
+	}
+	p, err = NewLocalPRProvider(repoDir, Options{Dir: prDir})
+	if err != nil {
+		t.Fatal(err)
+	}
+	create(p, 5)
+}
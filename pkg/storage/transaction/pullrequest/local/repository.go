@@ -0,0 +1,78 @@
+package local
+
+import (
+	"fmt"
+	"path"
+	"time"
+
+	"github.com/go-git/go-billy/v5/memfs"
+	git "github.com/go-git/go-git/v5"
+	"github.com/go-git/go-git/v5/plumbing"
+	"github.com/go-git/go-git/v5/plumbing/object"
+	"github.com/weaveworks/libgitops/pkg/gitdir"
+)
+
+// InitBareRepository creates a bare Git repository in dir, to be used as a local remote, e.g. in tests
+// or air-gapped environments, by setting gitdir.GitDirectoryOptions.URL to dir. As empty repositories
+// can't be cloned, an initial commit with the given files is created on branch, which is also set as
+// the default branch. The keys of files are paths relative to the repository root, using forward slashes.
+func InitBareRepository(dir, branch string, files map[string][]byte) error {
+	repo, err := git.PlainInit(dir, true)
+	if err != nil {
+		return fmt.Errorf("git init error: %v", err)
+	}
+	// Make branch the default branch of the repository
+	branchRef := plumbing.NewBranchReferenceName(branch)
+	if err := repo.Storer.SetReference(plumbing.NewSymbolicReference(plumbing.HEAD, branchRef)); err != nil {
+		return err
+	}
+
+	wt, err := openWorktree(repo)
+	if err != nil {
+		return err
+	}
+	for file, content := range files {
+		if err := writeFile(wt, file, content); err != nil {
+			return err
+		}
+	}
+	_, err = wt.Commit("Initial commit", &git.CommitOptions{
+		Author: &object.Signature{Name: "libgitops", Email: "libgitops@localhost", When: time.Now()},
+	})
+	if err != nil {
+		return fmt.Errorf("git commit error: %v", err)
+	}
+	return nil
+}
+
+// openWorktree returns an in-memory worktree for the bare repository. The index and HEAD of the
+// worktree are only kept in memory, but commits and branch updates are written to the repository.
+func openWorktree(repo *git.Repository) (*git.Worktree, error) {
+	wtRepo, err := git.Open(gitdir.NewWorktreeStorer(repo.Storer), memfs.New())
+	if err != nil {
+		return nil, fmt.Errorf("git open error: %v", err)
+	}
+	return wtRepo.Worktree()
+}
+
+// writeFile writes and stages the file at the given path in the worktree
+func writeFile(wt *git.Worktree, file string, content []byte) error {
+	if err := wt.Filesystem.MkdirAll(path.Dir(file), 0755); err != nil {
+		return err
+	}
+	f, err := wt.Filesystem.Create(file)
+	if err != nil {
+		return err
+	}
+	if _, err := f.Write(content); err != nil {
+		_ = f.Close()
+		return err
+	}
+	if err := f.Close(); err != nil {
+		return err
+	}
+	if _, err := wt.Add(file); err != nil {
+		return fmt.Errorf("git add error: %v", err)
+	}
+	return nil
+}
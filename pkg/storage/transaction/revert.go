@@ -0,0 +1,129 @@
+package transaction
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/go-git/go-git/v5/plumbing/object"
+	"github.com/weaveworks/libgitops/pkg/storage"
+)
+
+// shortSHALength is how many characters of a commit SHA are used in stream names
+const shortSHALength = 7
+
+// Revert undoes the changes of the given commit in a new transaction, described by result.
+func (s *GitStorage) Revert(ctx context.Context, commit string, result CommitResult) (*PullRequest, error) {
+	commit, err := s.gitDir.ResolveRevision(commit)
+	if err != nil {
+		return nil, err
+	}
+	// Merge commits are reverted relative to their first parent
+	parent, err := s.gitDir.ResolveRevision(commit + "^")
+	if err != nil {
+		return nil, fmt.Errorf("cannot revert commit %s without parent: %w", commit, err)
+	}
+
+	streamName := fmt.Sprintf("revert-%s-", commit[:shortSHALength])
+	return s.randomTransaction(ctx, streamName, func(ctx context.Context, tx *txWorktree) (CommitResult, error) {
+		changes, err := s.gitDir.Diff(parent, commit)
+		if err != nil {
+			return nil, err
+		}
+
+		for _, change := range changes {
+			// Remove files that were created, or renamed to, in the commit
+			if len(change.To) != 0 && change.To != change.From {
+				if err := s.verifyUnchangedSince(tx, commit, change.To); err != nil {
+					return nil, err
+				}
+				if err := s.fs.Remove(tx.repoPath(change.To)); err != nil {
+					return nil, err
+				}
+			}
+			// Restore files that were modified, deleted or renamed from, in the commit
+			if len(change.From) != 0 {
+				if err := s.verifyUnchangedSince(tx, commit, change.From); err != nil {
+					return nil, err
+				}
+				content, err := s.gitDir.ReadFileAtRevision(parent, change.From)
+				if err != nil {
+					return nil, err
+				}
+				if err := storage.WriteFile(s.fs, tx.repoPath(change.From), content); err != nil {
+					return nil, err
+				}
+			}
+		}
+		return result, nil
+	})
+}
+
+// RollbackObject restores the given Object to how it was at the given revision in a new transaction,
+// described by result.
+func (s *GitStorage) RollbackObject(ctx context.Context, key storage.ObjectKey, rev string, result CommitResult) (*PullRequest, error) {
+	_, revRaw, err := s.storageAt(rev)
+	if err != nil {
+		return nil, err
+	}
+	// Get the file and content of the Object at the revision
+	revFile, err := revRaw.realPath(key)
+	if err != nil {
+		return nil, err
+	}
+	content, err := revRaw.readFile(revFile)
+	if err != nil {
+		return nil, err
+	}
+
+	streamName := fmt.Sprintf("rollback-%s-%s-", strings.ToLower(key.GetKind()), key.GetIdentifier())
+	return s.randomTransaction(ctx, streamName, func(ctx context.Context, tx *txWorktree) (CommitResult, error) {
+		// Overwrite the current file of the Object, or restore the file it was in if it has been deleted since
+		file, err := tx.raw.GetMapping(key)
+		if err != nil {
+			rel, err := filepath.Rel(s.gitDir.Dir(), revFile)
+			if err != nil {
+				return nil, err
+			}
+			file = tx.repoPath(filepath.ToSlash(rel))
+		}
+		if err := storage.WriteFile(s.fs, file, content); err != nil {
+			return nil, err
+		}
+		return result, nil
+	})
+}
+
+// verifyUnchangedSince makes sure the file at path, relative to the repository root, in the worktree of tx is
+// the same as at the given commit. If the file didn't exist at the commit, it must not exist in the worktree.
+func (s *GitStorage) verifyUnchangedSince(tx *txWorktree, commit, path string) error {
+	expected, expectedErr := s.gitDir.ReadFileAtRevision(commit, path)
+	if expectedErr != nil && !errors.Is(expectedErr, object.ErrFileNotFound) {
+		return expectedErr
+	}
+	actual, actualErr := s.readFile(tx.repoPath(path))
+
+	switch {
+	case expectedErr != nil && os.IsNotExist(actualErr):
+		return nil
+	case expectedErr == nil && actualErr == nil && bytes.Equal(expected, actual):
+		return nil
+	case actualErr != nil && !os.IsNotExist(actualErr):
+		return actualErr
+	}
+	return fmt.Errorf("%w: %q", ErrRevertConflict, path)
+}
+
+// repoPath returns the absolute path for the given path relative to the repository root
+func (s *GitStorage) repoPath(path string) string {
+	return filepath.Join(s.gitDir.Dir(), filepath.FromSlash(path))
+}
+
+// readFile reads the file in the main clone, or the worktree of a transaction
+func (s *GitStorage) readFile(file string) ([]byte, error) {
+	return storage.ReadFile(s.fs, file)
+}
@@ -0,0 +1,87 @@
+package transaction
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"testing"
+
+	git "github.com/go-git/go-git/v5"
+	"github.com/go-git/go-git/v5/plumbing"
+	"github.com/weaveworks/libgitops/pkg/storage"
+)
+
+// remoteBranch returns the single branch on the remote whose name starts with prefix
+func remoteBranch(t *testing.T, repoDir, prefix string) string {
+	t.Helper()
+	repo, err := git.PlainOpen(repoDir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	iter, err := repo.Branches()
+	if err != nil {
+		t.Fatal(err)
+	}
+	var branches []string
+	_ = iter.ForEach(func(ref *plumbing.Reference) error {
+		if name := ref.Name().Short(); strings.HasPrefix(name, prefix) {
+			branches = append(branches, name)
+		}
+		return nil
+	})
+	if len(branches) != 1 {
+		t.Fatalf("got branches %v with prefix %q, want one", branches, prefix)
+	}
+	return branches[0]
+}
+
+func TestRevert(t *testing.T) {
+	repoDir := newTestRepository(t, map[string]string{"cars/a.yaml": carYAML("a", "volvo")})
+	s := newTestGitStorage(t, repoDir, GitStorageOptions{})
+	first, err := s.gitDir.ResolveRevision("HEAD")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	writer := newTestGitDirectory(t, repoDir)
+	commit := commitTestFiles(t, writer, map[string]string{
+		"cars/a.yaml": carYAML("a", "saab"),
+		"cars/b.yaml": carYAML("b", "audi"),
+	})
+	syncTestGitStorage(t, s)
+
+	ctx := context.Background()
+	result := &GenericCommitResult{AuthorName: "Test", AuthorEmail: "test@example.com", Title: "Revert"}
+	if _, err := s.Revert(ctx, commit, result); err != nil {
+		t.Fatal(err)
+	}
+	reverted, err := s.BranchStorage(ctx, remoteBranch(t, repoDir, "revert-"+commit[:shortSHALength]))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if brand, err := carBrand(reverted, "a"); err != nil || brand != "volvo" {
+		t.Errorf("brand of a = %q, %v after revert, want %q", brand, err, "volvo")
+	}
+	if _, err := carBrand(reverted, "b"); !errors.Is(err, storage.ErrNotTracked) {
+		t.Errorf("Get(b) after revert error = %v, want ErrNotTracked", err)
+	}
+
+	// The object can be rolled back to the first commit, regardless of later changes
+	commitTestFiles(t, writer, map[string]string{"cars/a.yaml": carYAML("a", "bmw")})
+	syncTestGitStorage(t, s)
+	if _, err := s.RollbackObject(ctx, carKey("a"), first, result); err != nil {
+		t.Fatal(err)
+	}
+	rolledBack, err := s.BranchStorage(ctx, remoteBranch(t, repoDir, "rollback-car-default/a-"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if brand, err := carBrand(rolledBack, "a"); err != nil || brand != "volvo" {
+		t.Errorf("brand of a = %q, %v after rollback, want %q", brand, err, "volvo")
+	}
+
+	// The commit can't be reverted anymore, as a has been modified since
+	if _, err := s.Revert(ctx, commit, result); !errors.Is(err, ErrRevertConflict) {
+		t.Errorf("Revert() of a modified file error = %v, want ErrRevertConflict", err)
+	}
+}
@@ -0,0 +1,195 @@
+package transaction
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"path/filepath"
+
+	"github.com/go-git/go-git/v5/plumbing/object"
+	"github.com/weaveworks/libgitops/pkg/filter"
+	"github.com/weaveworks/libgitops/pkg/gitdir"
+	"github.com/weaveworks/libgitops/pkg/runtime"
+	"github.com/weaveworks/libgitops/pkg/serializer"
+	"github.com/weaveworks/libgitops/pkg/storage"
+	"github.com/weaveworks/libgitops/pkg/util/watcher"
+)
+
+// ErrReadOnly is returned when trying to write to a storage for a fixed revision.
+var ErrReadOnly = errors.New("the storage is read-only")
+
+// GitStorage implements RevisionStorage.
+var _ RevisionStorage = &GitStorage{}
+
+// GetAtRevision returns the Object for the given key, as it was at the given revision.
+func (s *GitStorage) GetAtRevision(key storage.ObjectKey, rev string) (runtime.Object, error) {
+	revStorage, _, err := s.storageAt(rev)
+	if err != nil {
+		return nil, err
+	}
+	return revStorage.Get(key)
+}
+
+// ListAtRevision lists the Objects of the given kind, as they were at the given revision.
+func (s *GitStorage) ListAtRevision(kind storage.KindKey, rev string, opts ...filter.ListOption) ([]runtime.Object, error) {
+	revStorage, _, err := s.storageAt(rev)
+	if err != nil {
+		return nil, err
+	}
+	return revStorage.List(kind, opts...)
+}
+
+// ReadStorageAt returns a read-only view of the Objects as they were at the given revision.
+func (s *GitStorage) ReadStorageAt(rev string) (storage.ReadStorage, error) {
+	revStorage, _, err := s.storageAt(rev)
+	if err != nil {
+		return nil, err
+	}
+	return revStorage, nil
+}
+
+// BranchStorage fetches the given branch and returns a read-only view of its Objects.
+// Fetching takes the lock of the GitDirectory exclusively, like pulling.
+func (s *GitStorage) BranchStorage(ctx context.Context, branch string) (storage.ReadStorage, error) {
+	commit, err := s.gitDir.FetchBranch(ctx, branch)
+	if err != nil {
+		return nil, err
+	}
+	return s.ReadStorageAt(commit)
+}
+
+// History returns the commits of the main branch that changed the file of the given Object, newest first.
+func (s *GitStorage) History(key storage.ObjectKey) ([]gitdir.Commit, error) {
+	file, err := s.raw.GetMapping(key)
+	if err != nil {
+		return nil, err
+	}
+	path, err := filepath.Rel(s.gitDir.Dir(), file)
+	if err != nil {
+		return nil, err
+	}
+	return s.gitDir.History(filepath.ToSlash(path))
+}
+
+// storageAt returns a read-only Storage for the given revision, backed by the object database
+func (s *GitStorage) storageAt(rev string) (storage.Storage, *revisionRawStorage, error) {
+	commit, err := s.gitDir.ResolveRevision(rev)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	raw := &revisionRawStorage{
+		gitDir: s.gitDir,
+		commit: commit,
+		dir:    s.rootDir,
+	}
+	revStorage := storage.NewGenericStorage(raw, s.s.Serializer(), []runtime.IdentifierFactory{runtime.Metav1NameIdentifier})
+
+	// Consider the same files as for the main branch
+	repoFiles, err := s.gitDir.ListFilesAtRevision(commit)
+	if err != nil {
+		return nil, nil, err
+	}
+	files := make([]string, 0, len(repoFiles))
+	for _, repoFile := range repoFiles {
+		file := s.repoPath(repoFile)
+		if watcher.MatchesOptions(s.rootDir, file, s.walkOpts) {
+			files = append(files, file)
+		}
+	}
+
+	raw.fileMappings = mapFiles(files, raw.readFile, revStorage)
+	return revStorage, raw, nil
+}
+
+// revisionRawStorage is a read-only RawStorage for the files in the given
+// commit. Like the GenericMappedRawStorage, it maps keys to absolute paths
+// in the repository directory, but reads them from the object database.
+type revisionRawStorage struct {
+	gitDir       gitdir.GitDirectory
+	commit       string
+	dir          string
+	fileMappings map[storage.ObjectKey]string
+}
+
+var _ storage.RawStorage = &revisionRawStorage{}
+
+func (r *revisionRawStorage) realPath(key storage.ObjectKey) (string, error) {
+	path, ok := r.fileMappings[key]
+	if !ok {
+		return "", fmt.Errorf("revisionRawStorage: cannot resolve %q at %s: %w", key, r.commit, storage.ErrNotTracked)
+	}
+	return path, nil
+}
+
+func (r *revisionRawStorage) readFile(file string) ([]byte, error) {
+	path, err := filepath.Rel(r.gitDir.Dir(), file)
+	if err != nil {
+		return nil, err
+	}
+	content, err := r.gitDir.ReadFileAtRevision(r.commit, filepath.ToSlash(path))
+	if errors.Is(err, object.ErrFileNotFound) {
+		return nil, fmt.Errorf("%v: %w", err, storage.ErrNotFound)
+	}
+	return content, err
+}
+
+func (r *revisionRawStorage) Read(key storage.ObjectKey) ([]byte, error) {
+	file, err := r.realPath(key)
+	if err != nil {
+		return nil, err
+	}
+	return r.readFile(file)
+}
+
+func (r *revisionRawStorage) Exists(key storage.ObjectKey) bool {
+	_, err := r.realPath(key)
+	return err == nil
+}
+
+func (r *revisionRawStorage) Write(_ storage.ObjectKey, _ []byte) error {
+	return ErrReadOnly
+}
+
+func (r *revisionRawStorage) Delete(_ storage.ObjectKey) error {
+	return ErrReadOnly
+}
+
+func (r *revisionRawStorage) List(kind storage.KindKey) ([]storage.ObjectKey, error) {
+	result := make([]storage.ObjectKey, 0)
+	for key := range r.fileMappings {
+		// Include objects with the same kind and group, ignore version mismatches
+		if key.EqualsGVK(kind, false) {
+			result = append(result, key)
+		}
+	}
+	return result, nil
+}
+
+// Checksum returns the commit SHA, as the contents can't change for a given revision.
+func (r *revisionRawStorage) Checksum(key storage.ObjectKey) (string, error) {
+	if _, err := r.realPath(key); err != nil {
+		return "", err
+	}
+	return r.commit, nil
+}
+
+func (r *revisionRawStorage) ContentType(key storage.ObjectKey) (ct serializer.ContentType) {
+	if file, err := r.realPath(key); err == nil {
+		ct = storage.ContentTypes[filepath.Ext(file)] // Retrieve the correct format based on the extension
+	}
+	return
+}
+
+func (r *revisionRawStorage) WatchDir() string {
+	return r.dir
+}
+
+func (r *revisionRawStorage) GetKey(path string) (storage.ObjectKey, error) {
+	for key, p := range r.fileMappings {
+		if p == path {
+			return key, nil
+		}
+	}
+	return nil, fmt.Errorf("no mapping found for path %q", path)
+}
@@ -0,0 +1,66 @@
+package transaction
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	"github.com/weaveworks/libgitops/pkg/storage"
+)
+
+func TestReadStorageAt(t *testing.T) {
+	repoDir := newTestRepository(t, map[string]string{"cars/a.yaml": carYAML("a", "volvo")})
+	s := newTestGitStorage(t, repoDir, GitStorageOptions{})
+	first, err := s.gitDir.ResolveRevision("HEAD")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	writer := newTestGitDirectory(t, repoDir)
+	commitTestFiles(t, writer, map[string]string{
+		"cars/a.yaml": carYAML("a", "saab"),
+		"cars/b.yaml": carYAML("b", "audi"),
+	})
+	syncTestGitStorage(t, s)
+
+	// The old revision is read from the object database, the main storage is at the new commit
+	old, err := s.ReadStorageAt(first)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if brand, err := carBrand(old, "a"); err != nil || brand != "volvo" {
+		t.Errorf("brand of a = %q, %v at the first commit, want %q", brand, err, "volvo")
+	}
+	if _, err := carBrand(old, "b"); !errors.Is(err, storage.ErrNotTracked) {
+		t.Errorf("Get(b) at the first commit error = %v, want ErrNotTracked", err)
+	}
+	if brand, err := carBrand(s, "a"); err != nil || brand != "saab" {
+		t.Errorf("brand of a = %q, %v after sync, want %q", brand, err, "saab")
+	}
+}
+
+func TestBranchStorage(t *testing.T) {
+	repoDir := newTestRepository(t, map[string]string{"cars/a.yaml": carYAML("a", "volvo")})
+	s := newTestGitStorage(t, repoDir, GitStorageOptions{})
+
+	writer := newTestGitDirectory(t, repoDir)
+	if err := writer.CheckoutNewBranch("feature"); err != nil {
+		t.Fatal(err)
+	}
+	commitTestFiles(t, writer, map[string]string{"cars/b.yaml": carYAML("b", "audi")})
+
+	// The branch is fetched, but not checked out
+	branch, err := s.BranchStorage(context.Background(), "feature")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if brand, err := carBrand(branch, "b"); err != nil || brand != "audi" {
+		t.Errorf("brand of b = %q, %v on the branch, want %q", brand, err, "audi")
+	}
+	if brand, err := carBrand(branch, "a"); err != nil || brand != "volvo" {
+		t.Errorf("brand of a = %q, %v on the branch, want %q", brand, err, "volvo")
+	}
+	if _, err := carBrand(s, "b"); err == nil {
+		t.Error("Get(b) from the main storage succeeded, want the branch not to be checked out")
+	}
+}
@@ -4,13 +4,22 @@ import (
 	"context"
 	"errors"
 
+	"github.com/weaveworks/libgitops/pkg/filter"
+	"github.com/weaveworks/libgitops/pkg/gitdir"
+	"github.com/weaveworks/libgitops/pkg/runtime"
 	"github.com/weaveworks/libgitops/pkg/storage"
 )
 
 var (
-	ErrAbortTransaction      = errors.New("transaction aborted")
-	ErrTransactionActive     = errors.New("transaction is active")
-	ErrNoPullRequestProvider = errors.New("no pull request provider given")
+	ErrAbortTransaction        = errors.New("transaction aborted")
+	ErrTransactionActive       = errors.New("transaction is active")
+	ErrNoPullRequestProvider   = errors.New("no pull request provider given")
+	ErrNoRepositoryRef         = errors.New("the pull request provider requires a repository ref")
+	ErrNotInTransaction        = errors.New("the storage can only be modified in a transaction")
+	ErrRevertConflict          = errors.New("the changes to revert have been modified since")
+	ErrPullRequestNotFound     = errors.New("no open pull request found")
+	ErrMergeMethodNotSupported = errors.New("the merge method isn't supported by the pull request provider")
+	ErrSubmoduleFile           = errors.New("files in submodules can't be modified in a transaction")
 )
 
 type TransactionFunc func(ctx context.Context, s storage.Storage) (CommitResult, error)
@@ -23,6 +32,40 @@ type TransactionStorage interface {
 	// The environment is made sure to be as up-to-date as possible before fn executes. When
 	// fn executes, the given storage can be used to modify the desired state. If you want to
 	// "commit" the changes made in fn, just return nil. If you want to abort, return ErrAbortTransaction.
-	// If you want to
-	Transaction(ctx context.Context, streamName string, fn TransactionFunc) error
+	// If you want to create a Pull Request, return a PullRequestResult from fn. The created Pull Request
+	// is then returned, otherwise the returned PullRequest is nil. Multiple transactions can run
+	// concurrently, and their changes aren't visible in the ReadStorage until merged.
+	Transaction(ctx context.Context, streamName string, fn TransactionFunc) (*PullRequest, error)
+}
+
+// RevisionStorage is a storage that keeps a history of its Objects, and allows
+// reading them as they were at a given revision (for Git: e.g. a commit SHA,
+// branch or tag), and undoing changes.
+type RevisionStorage interface {
+	// GetAtRevision returns the Object for the given key, as it was at the given revision.
+	// If the Object didn't exist at the revision, ErrNotFound is returned.
+	GetAtRevision(key storage.ObjectKey, rev string) (runtime.Object, error)
+	// ListAtRevision lists the Objects of the given kind, as they were at the given revision.
+	// Optionally, filters can be applied, like for List.
+	ListAtRevision(kind storage.KindKey, rev string, opts ...filter.ListOption) ([]runtime.Object, error)
+	// ReadStorageAt returns a read-only view of the Objects as they were at the given revision. It's backed
+	// by the object database, not the current files, so reading it isn't affected by transactions. Reads
+	// wait for pending fetches and pulls, but don't block other readers. Writes return ErrReadOnly.
+	ReadStorageAt(rev string) (storage.ReadStorage, error)
+	// BranchStorage fetches the latest state of the given branch, e.g. the stream branch of a Pull Request,
+	// and returns a read-only view of its Objects like ReadStorageAt. The fetch modifies the repository,
+	// hence it waits for all pending Git operations, and blocks new ones, including reads, until it's done.
+	BranchStorage(ctx context.Context, branch string) (storage.ReadStorage, error)
+	// History returns the commits that changed the given Object, newest first.
+	// If the Object doesn't exist currently, ErrNotFound is returned.
+	History(key storage.ObjectKey) ([]gitdir.Commit, error)
+
+	// Revert undoes the changes of the given commit in a new transaction, described by result. Like for
+	// Transaction, a Pull Request is created if result is a PullRequestResult. If any of the changed files
+	// have been modified since the commit, ErrRevertConflict is returned.
+	Revert(ctx context.Context, commit string, result CommitResult) (*PullRequest, error)
+	// RollbackObject restores the given Object to how it was at the given revision in a new transaction,
+	// described by result. Like for Transaction, a Pull Request is created if result is a PullRequestResult.
+	// If the Object didn't exist at the revision, ErrNotFound is returned.
+	RollbackObject(ctx context.Context, key storage.ObjectKey, rev string, result CommitResult) (*PullRequest, error)
 }
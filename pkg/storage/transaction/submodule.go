@@ -0,0 +1,77 @@
+package transaction
+
+import (
+	"fmt"
+	"path/filepath"
+
+	"github.com/weaveworks/libgitops/pkg/storage"
+)
+
+// submoduleOf returns the submodule the given file in the main clone belongs to, if any
+func (s *GitStorage) submoduleOf(file string) (string, bool) {
+	rel, err := filepath.Rel(s.gitDir.Dir(), file)
+	if err != nil {
+		return "", false
+	}
+	return s.submoduleOfRepoPath(rel)
+}
+
+// submoduleOfRepoPath returns the submodule the given path, relative to the repository root, belongs to, if any
+func (s *GitStorage) submoduleOfRepoPath(path string) (string, bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for _, submodule := range s.submodules {
+		if isSubPath(filepath.FromSlash(submodule), path) {
+			return submodule, true
+		}
+	}
+	return "", false
+}
+
+// submoduleMappings returns the mappings of the objects in submodules, to their files in the main clone
+func (s *GitStorage) submoduleMappings() map[storage.ObjectKey]string {
+	s.mu.Lock()
+	mappings := s.mappings
+	s.mu.Unlock()
+
+	m := map[storage.ObjectKey]string{}
+	for key, file := range mappings {
+		if _, ok := s.submoduleOf(file); ok {
+			m[key] = file
+		}
+	}
+	return m
+}
+
+// submoduleGuardRawStorage is the MappedRawStorage of a transaction. Submodules aren't checked out in
+// the worktree of a transaction, hence the objects in submodules are mapped to their files in the main
+// clone, so they can be read. Modifying them returns ErrSubmoduleFile, as the change couldn't be committed.
+type submoduleGuardRawStorage struct {
+	storage.MappedRawStorage
+	s *GitStorage
+}
+
+func (r *submoduleGuardRawStorage) Write(key storage.ObjectKey, content []byte) error {
+	if err := r.verifyNotInSubmodule(key); err != nil {
+		return err
+	}
+	return r.MappedRawStorage.Write(key, content)
+}
+
+func (r *submoduleGuardRawStorage) Delete(key storage.ObjectKey) error {
+	if err := r.verifyNotInSubmodule(key); err != nil {
+		return err
+	}
+	return r.MappedRawStorage.Delete(key)
+}
+
+func (r *submoduleGuardRawStorage) verifyNotInSubmodule(key storage.ObjectKey) error {
+	file, err := r.GetMapping(key)
+	if err != nil {
+		return nil // a new file, which is placed outside of submodules by placeNewFile
+	}
+	if submodule, ok := r.s.submoduleOf(file); ok {
+		return fmt.Errorf("%w: %s is stored in %q of submodule %q", ErrSubmoduleFile, key, file, submodule)
+	}
+	return nil
+}
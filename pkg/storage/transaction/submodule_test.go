@@ -0,0 +1,84 @@
+package transaction
+
+import (
+	"context"
+	"errors"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"testing"
+	"time"
+
+	"github.com/weaveworks/libgitops/cmd/sample-app/apis/sample/scheme"
+	"github.com/weaveworks/libgitops/pkg/gitdir"
+	"github.com/weaveworks/libgitops/pkg/storage"
+)
+
+func TestSubmoduleGuard(t *testing.T) {
+	baseDir := newTestRepository(t, map[string]string{"cars/b.yaml": carYAML("b", "saab")})
+	repoDir := newTestRepository(t, map[string]string{"cars/a.yaml": carYAML("a", "volvo")})
+
+	// Add the base repository as submodule at vendor/base
+	workDir, err := ioutil.TempDir("", "libgitops")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { _ = os.RemoveAll(workDir) })
+	for _, args := range [][]string{
+		{"clone", "-q", repoDir, "."},
+		{"submodule", "add", "-q", baseDir, "vendor/base"},
+		{"commit", "-q", "-m", "Add base"},
+		{"push", "-q", "origin", "master"},
+	} {
+		cmd := exec.Command("git", append([]string{
+			"-c", "user.name=Test", "-c", "user.email=test@example.com", "-c", "protocol.file.allow=always",
+		}, args...)...)
+		cmd.Dir = workDir
+		if out, err := cmd.CombinedOutput(); err != nil {
+			t.Fatalf("git %v: %v: %s", args, err, out)
+		}
+	}
+
+	d, err := gitdir.NewGitDirectory(nil, gitdir.GitDirectoryOptions{URL: repoDir, Interval: time.Hour, RecurseSubmodules: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { _ = d.Cleanup() })
+	if err := d.StartCheckoutLoop(); err != nil {
+		t.Fatal(err)
+	}
+	ts, err := NewGitStorage(d, nil, scheme.Serializer)
+	if err != nil {
+		t.Fatal(err)
+	}
+	s := ts.(*GitStorage)
+	if brand, err := carBrand(s, "b"); err != nil || brand != "saab" {
+		t.Fatalf("brand of b in the submodule = %q, %v, want %q", brand, err, "saab")
+	}
+
+	// The objects in the submodule can be read, but not modified in a transaction
+	ctx := context.Background()
+	for name, modify := range map[string]func(tx storage.Storage) error{
+		"write": func(tx storage.Storage) error {
+			return tx.RawStorage().Write(carKey("b"), []byte(carYAML("b", "audi")))
+		},
+		"delete": func(tx storage.Storage) error {
+			return tx.RawStorage().Delete(carKey("b"))
+		},
+	} {
+		branch := name + "-b"
+		_, err := s.UpsertTransaction(ctx, branch, UpsertOptions{}, func(ctx context.Context, tx storage.Storage) (CommitResult, error) {
+			if brand, err := carBrand(tx, "b"); err != nil || brand != "saab" {
+				t.Errorf("%s: brand of b in the transaction = %q, %v, want %q", name, brand, err, "saab")
+			}
+			return &GenericCommitResult{AuthorName: "Test", AuthorEmail: "test@example.com", Title: "Modify b"}, modify(tx)
+		})
+		if !errors.Is(err, ErrSubmoduleFile) {
+			t.Errorf("%s: transaction error = %v, want ErrSubmoduleFile", name, err)
+		}
+		// No commit was created, hence the branch wasn't pushed
+		if sha, err := d.FetchBranch(ctx, branch); err == nil {
+			t.Errorf("%s: the branch was pushed at %s, want no commit", name, sha)
+		}
+	}
+}
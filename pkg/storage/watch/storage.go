@@ -34,13 +34,19 @@ func NewManifestStorage(manifestDir string, ser serializer.Serializer) (update.E
 // Note: This WatchStorage only works for one-frame files (i.e. only one YAML document
 // per file is supported).
 func NewGenericWatchStorage(s storage.Storage) (update.EventStorage, error) {
+	return NewGenericWatchStorageWithOptions(s, watcher.DefaultOptions())
+}
+
+// NewGenericWatchStorageWithOptions is like NewGenericWatchStorage, but allows customizing
+// the options of the underlying watcher, e.g. to only watch some subdirectories.
+func NewGenericWatchStorageWithOptions(s storage.Storage, opts watcher.Options) (update.EventStorage, error) {
 	ws := &GenericWatchStorage{
 		Storage: s,
 	}
 
 	var err error
 	var files []string
-	if ws.watcher, files, err = watcher.NewFileWatcher(s.RawStorage().WatchDir()); err != nil {
+	if ws.watcher, files, err = watcher.NewFileWatcherWithOptions(s.RawStorage().WatchDir(), opts); err != nil {
 		return nil, err
 	}
 
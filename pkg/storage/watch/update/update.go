@@ -11,6 +11,9 @@ type Update struct {
 	Event         ObjectEvent
 	PartialObject runtime.PartialObject
 	Storage       storage.Storage
+	// Revision optionally describes the revision of the Storage
+	// that caused the update, e.g. a Git commit SHA
+	Revision string
 }
 
 // UpdateStream is a channel of updates.
@@ -4,14 +4,44 @@ import (
 	"os"
 	"path/filepath"
 	"strings"
+
+	log "github.com/sirupsen/logrus"
 )
 
 func (w *FileWatcher) getFiles() ([]string, error) {
-	return WalkDirectoryForFiles(w.dir, w.opts.ValidExtensions, w.opts.ExcludeDirs)
+	return WalkDirectoryForFilesWithOptions(w.dir, w.opts)
+}
+
+// watchDirs returns the directories to watch, based on the Paths option.
+// Like when walking, the paths that don't exist are skipped.
+func (w *FileWatcher) watchDirs() []string {
+	var dirs []string
+	for _, dir := range SubDirectories(w.dir, w.opts.Paths) {
+		if _, err := os.Stat(dir); os.IsNotExist(err) {
+			log.Warnf("FileWatcher: Not watching %q, as it doesn't exist", dir)
+			continue
+		}
+		dirs = append(dirs, dir)
+	}
+	return dirs
 }
 
 func (w *FileWatcher) validFile(path string) bool {
-	return isValidFile(path, w.opts.ValidExtensions, w.opts.ExcludeDirs)
+	return MatchesOptions(w.dir, path, w.opts)
+}
+
+// SubDirectories joins the given paths relative to dir. If no paths
+// are given, dir itself is returned as the only directory.
+func SubDirectories(dir string, paths []string) []string {
+	if len(paths) == 0 {
+		return []string{dir}
+	}
+
+	dirs := make([]string, 0, len(paths))
+	for _, p := range paths {
+		dirs = append(dirs, filepath.Join(dir, p))
+	}
+	return dirs
 }
 
 // WalkDirectoryForFiles discovers all subdirectories and
@@ -36,11 +66,55 @@ func WalkDirectoryForFiles(dir string, validExts, excludeDirs []string) (files [
 	return
 }
 
+// WalkDirectoriesForFiles runs WalkDirectoryForFiles for all given
+// directories, skipping the ones that don't exist
+func WalkDirectoriesForFiles(dirs []string, validExts, excludeDirs []string) (files []string, err error) {
+	for _, dir := range dirs {
+		if _, err = os.Stat(dir); os.IsNotExist(err) {
+			continue
+		}
+
+		var dirFiles []string
+		if dirFiles, err = WalkDirectoryForFiles(dir, validExts, excludeDirs); err != nil {
+			return
+		}
+		files = append(files, dirFiles...)
+	}
+
+	return files, nil
+}
+
+// WalkDirectoryForFilesWithOptions returns a list of valid files in the
+// given directory, restricted to opts.Paths, and filtered by the file
+// extensions, excluded directories and include/exclude patterns in opts
+func WalkDirectoryForFilesWithOptions(dir string, opts Options) (files []string, err error) {
+	candidates, err := WalkDirectoriesForFiles(SubDirectories(dir, opts.Paths), opts.ValidExtensions, opts.ExcludeDirs)
+	if err != nil {
+		return nil, err
+	}
+
+	for _, file := range candidates {
+		if matchesPatterns(dir, file, opts.Include, opts.Exclude) {
+			files = append(files, file)
+		}
+	}
+
+	return
+}
+
 // isValidFile is used to filter out all unsupported
 // files based on if their extension is unknown or
 // if their path contains an excluded directory
 func isValidFile(path string, validExts, excludeDirs []string) bool {
 	parts := strings.Split(filepath.Clean(path), string(os.PathSeparator))
+	for i := 0; i < len(parts)-1; i++ {
+		for _, exclude := range excludeDirs {
+			if parts[i] == exclude {
+				return false
+			}
+		}
+	}
+
 	ext := filepath.Ext(parts[len(parts)-1])
 	for _, suffix := range validExts {
 		if ext == suffix {
@@ -48,13 +122,83 @@ func isValidFile(path string, validExts, excludeDirs []string) bool {
 		}
 	}
 
-	for i := 0; i < len(parts)-1; i++ {
-		for _, exclude := range excludeDirs {
-			if parts[i] == exclude {
-				return false
+	return false
+}
+
+// MatchesOptions returns true if the file at path would be considered when walking or
+// watching dir with the given options. The file itself doesn't need to exist.
+func MatchesOptions(dir, path string, opts Options) bool {
+	if !isValidFile(path, opts.ValidExtensions, opts.ExcludeDirs) {
+		return false
+	}
+
+	if len(opts.Paths) != 0 {
+		inPaths := false
+		for _, subDir := range SubDirectories(dir, opts.Paths) {
+			if isInDirectory(subDir, path) {
+				inPaths = true
+				break
+			}
+		}
+
+		if !inPaths {
+			return false
+		}
+	}
+
+	return matchesPatterns(dir, path, opts.Include, opts.Exclude)
+}
+
+// MatchesPatterns returns true if the relative path matches any of the include patterns
+// (or if there are none), and none of the exclude patterns. Patterns have the syntax of
+// filepath.Match. A pattern containing a slash is matched against the relative path,
+// otherwise it's matched against every path element. A pattern matching a directory
+// applies to all files inside it.
+func MatchesPatterns(path string, include, exclude []string) bool {
+	if len(include) != 0 && !matchesAny(path, include) {
+		return false
+	}
+
+	return !matchesAny(path, exclude)
+}
+
+func matchesPatterns(dir, path string, include, exclude []string) bool {
+	if len(include) == 0 && len(exclude) == 0 {
+		return true
+	}
+
+	relPath, err := filepath.Rel(dir, path)
+	if err != nil {
+		return false
+	}
+
+	return MatchesPatterns(relPath, include, exclude)
+}
+
+// matchesAny returns true if any of the patterns match the path or one of its parent directories
+func matchesAny(path string, patterns []string) bool {
+	parts := strings.Split(filepath.Clean(path), string(os.PathSeparator))
+	for _, pattern := range patterns {
+		pattern = filepath.Clean(pattern)
+		for i := range parts {
+			// Match the pattern against the path element only if the pattern doesn't contain a slash,
+			// otherwise match it against the path (of the directory) relative to the base directory
+			candidate := parts[i]
+			if strings.ContainsRune(pattern, os.PathSeparator) {
+				candidate = filepath.Join(parts[:i+1]...)
+			}
+
+			if ok, _ := filepath.Match(pattern, candidate); ok {
+				return true
 			}
 		}
 	}
 
 	return false
 }
+
+// isInDirectory returns true if path is dir itself or located inside of it
+func isInDirectory(dir, path string) bool {
+	relPath, err := filepath.Rel(dir, path)
+	return err == nil && relPath != ".." && !strings.HasPrefix(relPath, ".."+string(os.PathSeparator))
+}
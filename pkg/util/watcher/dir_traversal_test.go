@@ -0,0 +1,118 @@
+package watcher
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"sort"
+	"testing"
+)
+
+func TestMatchesPatterns(t *testing.T) {
+	tests := []struct {
+		name    string
+		path    string
+		include []string
+		exclude []string
+		want    bool
+	}{
+		{"no patterns", "cars/foo.yaml", nil, nil, true},
+		{"include file name", "cars/foo.yaml", []string{"*.yaml"}, nil, true},
+		{"include other extension", "cars/foo.json", []string{"*.yaml"}, nil, false},
+		{"include directory", "cars/foo.yaml", []string{"cars"}, nil, true},
+		{"include nested path", "clusters/prod/foo.yaml", []string{"clusters/prod"}, nil, true},
+		{"include other nested path", "clusters/dev/foo.yaml", []string{"clusters/prod"}, nil, false},
+		{"exclude directory", "docs/foo.yaml", nil, []string{"docs"}, false},
+		{"exclude nested directory name", "cars/ci/foo.yaml", nil, []string{"ci"}, false},
+		{"exclude takes precedence", "cars/foo.yaml", []string{"cars"}, []string{"foo.*"}, false},
+		{"exclude not matching", "cars/foo.yaml", nil, []string{"docs", "*.json"}, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := MatchesPatterns(tt.path, tt.include, tt.exclude); got != tt.want {
+				t.Errorf("MatchesPatterns(%q, %v, %v) = %v, want %v", tt.path, tt.include, tt.exclude, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestSubDirectories(t *testing.T) {
+	if got := SubDirectories("/repo", nil); !reflect.DeepEqual(got, []string{"/repo"}) {
+		t.Errorf("SubDirectories without paths = %v, want [/repo]", got)
+	}
+	want := []string{"/repo/cars", "/repo/clusters/prod"}
+	if got := SubDirectories("/repo", []string{"cars", "clusters/prod/"}); !reflect.DeepEqual(got, want) {
+		t.Errorf("SubDirectories = %v, want %v", got, want)
+	}
+}
+
+func TestWalkDirectoryForFilesWithOptions(t *testing.T) {
+	dir, err := ioutil.TempDir("", "watcher")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	for _, file := range []string{
+		"cars/a.yaml",
+		"cars/ci/b.yaml",
+		"cars/c.json",
+		"cars/README.md",
+		"cars/.git/d.yaml",
+		"docs/e.yaml",
+		"motorcycles/f.yaml",
+	} {
+		path := filepath.Join(dir, file)
+		if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+			t.Fatal(err)
+		}
+		if err := ioutil.WriteFile(path, nil, 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	opts := Options{ExcludeDirs: []string{".git"}, ValidExtensions: []string{".yaml", ".json"}}
+	tests := []struct {
+		name    string
+		paths   []string
+		include []string
+		exclude []string
+		want    []string
+	}{
+		{"all files", nil, nil, nil, []string{"cars/a.yaml", "cars/c.json", "cars/ci/b.yaml", "docs/e.yaml", "motorcycles/f.yaml"}},
+		{"paths", []string{"cars", "motorcycles"}, nil, nil, []string{"cars/a.yaml", "cars/c.json", "cars/ci/b.yaml", "motorcycles/f.yaml"}},
+		{"missing path is skipped", []string{"docs", "trucks"}, nil, nil, []string{"docs/e.yaml"}},
+		{"include", nil, []string{"*.json", "docs"}, nil, []string{"cars/c.json", "docs/e.yaml"}},
+		{"exclude", []string{"cars"}, nil, []string{"ci"}, []string{"cars/a.yaml", "cars/c.json"}},
+		{"include and exclude", nil, []string{"*.yaml"}, []string{"cars/ci", "motorcycles"}, []string{"cars/a.yaml", "docs/e.yaml"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			o := opts
+			o.Paths, o.Include, o.Exclude = tt.paths, tt.include, tt.exclude
+			files, err := WalkDirectoryForFilesWithOptions(dir, o)
+			if err != nil {
+				t.Fatal(err)
+			}
+			got := make([]string, 0, len(files))
+			for _, file := range files {
+				rel, _ := filepath.Rel(dir, file)
+				got = append(got, filepath.ToSlash(rel))
+				// Walking and watching must consider the same files
+				if !MatchesOptions(dir, file, o) {
+					t.Errorf("MatchesOptions(%q) = false for a walked file", rel)
+				}
+			}
+			sort.Strings(got)
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("WalkDirectoryForFilesWithOptions() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+
+	// Like when walking, missing paths aren't watched
+	w := &FileWatcher{dir: dir, opts: Options{Paths: []string{"cars", "trucks"}}}
+	if got, want := w.watchDirs(), []string{filepath.Join(dir, "cars")}; !reflect.DeepEqual(got, want) {
+		t.Errorf("watchDirs() = %v, want %v", got, want)
+	}
+}
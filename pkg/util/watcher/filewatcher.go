@@ -64,6 +64,17 @@ type Options struct {
 	BatchTimeout time.Duration
 	// ValidExtensions specifies what file extensions to look at
 	ValidExtensions []string
+	// Paths restricts watching to the given subdirectories, relative
+	// to the watched directory. If empty, the whole directory is watched.
+	// Paths that don't exist are skipped, both when walking and watching
+	Paths []string
+	// Include specifies glob patterns for the files to look at, relative
+	// to the watched directory. If empty, all files are included.
+	// See MatchesPatterns for the pattern syntax
+	Include []string
+	// Exclude specifies glob patterns for the files to ignore, relative
+	// to the watched directory. See MatchesPatterns for the pattern syntax
+	Exclude []string
 }
 
 // DefaultOptions returns the default options
@@ -94,10 +105,15 @@ func NewFileWatcherWithOptions(dir string, opts Options) (w *FileWatcher, files
 		opts:    opts,
 	}
 
-	log.Tracef("FileWatcher: Starting recursive watch for %q", dir)
-	if err = notify.Watch(path.Join(dir, "..."), w.events, listenEvents...); err != nil {
-		notify.Stop(w.events)
-	} else if files, err = w.getFiles(); err == nil {
+	for _, watchDir := range w.watchDirs() {
+		log.Tracef("FileWatcher: Starting recursive watch for %q", watchDir)
+		if err = notify.Watch(path.Join(watchDir, "..."), w.events, listenEvents...); err != nil {
+			notify.Stop(w.events)
+			return
+		}
+	}
+
+	if files, err = w.getFiles(); err == nil {
 		w.monitor = sync.RunMonitor(w.monitorFunc)
 		w.dispatcher = sync.RunMonitor(w.dispatchFunc)
 	}